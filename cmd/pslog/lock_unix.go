@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// supportPidLock 是否支持通过 pid 文件防止重复启动
+const supportPidLock = true
+
+// lockFile 非阻塞的排他锁, 进程退出后自动释放
+func lockFile(fh *os.File) error {
+	return syscall.Flock(int(fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// supportPidLock windows 不支持 flock, 不能通过 pid 文件防止重复启动
+const supportPidLock = false
+
+// lockFile windows 不支持 flock
+func lockFile(fh *os.File) error {
+	return errors.New("flock is not supported on windows")
+}
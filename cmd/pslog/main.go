@@ -0,0 +1,147 @@
+// pslog 根据配置文件运行 ps-log 采集
+//
+// 用法:
+//
+//	pslog -c pslog.yaml [-pid pslog.pid] [-watch]
+//	pslog -c pslog.yaml -t // 只校验配置
+//
+// 信号:
+//
+//	SIGINT, SIGTERM 保存偏移量后退出
+//	SIGHUP 重新加载配置
+//
+// 注: windows 不支持 -pid
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+
+	pslog "gitee.com/xuesongtao/ps-log"
+	plg "gitee.com/xuesongtao/ps-log/log"
+	tw "github.com/olekukonko/tablewriter"
+)
+
+const (
+	exitErr    = 1 // 运行出错
+	exitConfig = 2 // 配置有误
+)
+
+var (
+	configFile = flag.String("c", "pslog.yaml", "配置文件, 后缀为 .json 时按 json 解析, 其他按 yaml 解析")
+	pidPath    = flag.String("pid", "", "pid 文件, 同时作为锁文件防止重复启动, 为空时不创建")
+	test       = flag.Bool("t", false, "只校验配置, 不运行")
+	watch      = flag.Bool("watch", false, "配置文件变化时自动重新加载")
+)
+
+func main() {
+	flag.Parse()
+	os.Exit(run())
+}
+
+func run() int {
+	c, err := pslog.LoadConfig(*configFile)
+	if err != nil {
+		printConfigErr(os.Stderr, err)
+		return exitConfig
+	}
+	printConfig(os.Stdout, *configFile, c)
+	if *test {
+		fmt.Fprintf(os.Stdout, "config %q is ok\n", *configFile)
+		return 0
+	}
+
+	if *pidPath != "" {
+		if !supportPidLock {
+			fmt.Fprintf(os.Stderr, "-pid is not supported on %s, it can not prevent duplicate start\n", runtime.GOOS)
+			return exitErr
+		}
+		pid, err := createPidFile(*pidPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitErr
+		}
+		defer pid.remove()
+	}
+
+	r, err := pslog.NewReloaderFromConfig(*configFile, c)
+	if err != nil {
+		printConfigErr(os.Stderr, err)
+		return exitErr
+	}
+	// 退出时会保存偏移量
+	defer r.Close()
+	r.WatchSignal(syscall.SIGHUP)
+	if *watch {
+		if err := r.WatchFile(); err != nil {
+			fmt.Fprintln(os.Stderr, "watch config is failed, err:", err)
+			return exitErr
+		}
+	}
+	plg.Infof("pslog is running, pid: %d, config: %q", os.Getpid(), *configFile)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	select {
+	case sig := <-sigCh:
+		plg.Infof("receive signal %q, it will exit", sig)
+		return 0
+	case <-r.Done():
+		plg.Error("ps-log is closed, it will exit")
+		return exitErr
+	}
+}
+
+// printConfigErr 输出配置错误, 每行一个出错的配置项
+func printConfigErr(w io.Writer, err error) {
+	var errs pslog.ConfigErrors
+	if !errors.As(err, &errs) {
+		fmt.Fprintln(w, "config is not ok, err:", err)
+		return
+	}
+	fmt.Fprintf(w, "config is not ok, %d errors:\n", len(errs))
+	for _, v := range errs {
+		fmt.Fprintf(w, "  %s: %v\n", v.Key, v.Err)
+	}
+}
+
+// printConfig 输出采集的 path 及对应的 handler, target, writer
+func printConfig(w io.Writer, filename string, c *pslog.Config) {
+	fmt.Fprintf(w, "config: %q, writers: %d, handlers: %d, paths: %d\n", filename, len(c.Writers), len(c.Handlers), len(c.Paths))
+	table := tw.NewWriter(w)
+	table.SetHeader([]string{"PATH", "HANDLER", "TAIL", "TARGETS", "TO"})
+	table.SetRowLine(true)
+	table.SetCenterSeparator("|")
+	table.SetAutoWrapText(false)
+	for _, path := range c.Paths {
+		name := path.Path
+		if path.Glob != "" {
+			name = path.Glob + "(glob)"
+		}
+		handler := c.Handlers[path.Handler]
+		targets := make([]string, 0, len(handler.Targets))
+		tos := make([]string, 0, len(handler.Targets))
+		for _, target := range handler.Targets {
+			targets = append(targets, target.Content)
+			tos = append(tos, strings.Join(target.To, ","))
+		}
+		table.Append([]string{name, path.Handler, fmt.Sprint(handler.Tail), strings.Join(targets, "\n"), strings.Join(tos, "\n")})
+	}
+	table.Render()
+
+	names := make([]string, 0, len(c.Writers))
+	for name, writer := range c.Writers {
+		names = append(names, name+"("+writer.Type+")")
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "writers: %s\n", strings.Join(names, ", "))
+}
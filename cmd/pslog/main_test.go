@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	pslog "gitee.com/xuesongtao/ps-log"
+)
+
+func TestPrintConfig(t *testing.T) {
+	_, err := pslog.ParseConfig([]byte("handlers:\n  app:\n    decoder: xml\npaths:\n  - path: a.log\n    handler: b\n"), pslog.ConfigYaml)
+	buf := new(bytes.Buffer)
+	printConfigErr(buf, err)
+	want := "config is not ok, 2 errors:\n" +
+		"  handlers.app.decoder: \"xml\" is not supported, optional: docker, cri\n" +
+		"  paths[0].handler: \"b\" is not in handlers\n"
+	if buf.String() != want {
+		t.Errorf("got: %q", buf.String())
+	}
+
+	c, err := pslog.LoadConfig("pslog.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	printConfig(buf, "pslog.yaml", c)
+	if got := buf.String(); !strings.Contains(got, "log/app.log") || !strings.Contains(got, "stdout,errors") {
+		t.Errorf("got: %s", got)
+	}
+}
+
+func TestCreatePidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pslog.pid")
+	pid, err := createPidFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(content)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("content: %q", content)
+	}
+
+	if runtime.GOOS != "windows" {
+		if _, err := createPidFile(path); err == nil {
+			t.Error("pid file should is locked")
+		}
+	}
+	pid.remove()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pid file should is removed, err: %v", err)
+	}
+}
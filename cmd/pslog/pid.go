@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pidFile pid 文件, 持有期间加锁, 防止同一个 pid 文件启动多个
+type pidFile struct {
+	path string
+	fh   *os.File
+}
+
+func createPidFile(path string) (*pidFile, error) {
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("os.OpenFile %q is failed, err: %v", path, err)
+	}
+	if err := lockFile(fh); err != nil {
+		content, _ := ioutil.ReadAll(fh)
+		fh.Close()
+		return nil, fmt.Errorf("%q is locked, pslog may be running, pid: %s, err: %v", path, strings.TrimSpace(string(content)), err)
+	}
+
+	if err := fh.Truncate(0); err != nil {
+		fh.Close()
+		return nil, fmt.Errorf("fh.Truncate %q is failed, err: %v", path, err)
+	}
+	if _, err := fh.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		fh.Close()
+		return nil, fmt.Errorf("fh.WriteAt %q is failed, err: %v", path, err)
+	}
+	return &pidFile{path: path, fh: fh}, nil
+}
+
+// remove 删除 pid 文件并释放锁
+func (p *pidFile) remove() {
+	os.Remove(p.path)
+	p.fh.Close()
+}
@@ -0,0 +1,632 @@
+package pslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/xuesongtao/ps-log/line"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ConfigYaml = "yaml"
+	ConfigJson = "json"
+
+	configNever      = "never"               // expire_at 为 never 时不过期
+	configTimeLayout = "2006-01-02 15:04:05" // expire_at 的格式
+)
+
+// WriterFactory 根据配置中的 params 创建 writer
+type WriterFactory func(params map[string]interface{}) (PsLogWriter, error)
+
+var (
+	writerMu       sync.RWMutex
+	writerRegistry = map[string]WriterFactory{
+		"stdout": func(params map[string]interface{}) (PsLogWriter, error) {
+			return &Stdout{}, nil
+		},
+		"file": func(params map[string]interface{}) (PsLogWriter, error) {
+			filename, _ := params["path"].(string)
+			if filename == "" {
+				return nil, errors.New("params.path is required")
+			}
+			return NewFile(filename)
+		},
+	}
+)
+
+// RegisterWriter 注册 writer 类型, 配置中 writers.<name>.type 为注册的类型, 内置: stdout, file
+// 说明: 同名会覆盖
+func RegisterWriter(typ string, factory WriterFactory) {
+	writerMu.Lock()
+	defer writerMu.Unlock()
+	writerRegistry[typ] = factory
+}
+
+func getWriterFactory(typ string) (WriterFactory, bool) {
+	writerMu.RLock()
+	defer writerMu.RUnlock()
+	factory, ok := writerRegistry[typ]
+	return factory, ok
+}
+
+// Duration 配置中的时间间隔, 如: 1s, 2m, 1h, -1 与 Handler 中的 -1 相同
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	if string(text) == "-1" {
+		*d = -1
+		return nil
+	}
+	dur, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(dur)
+	return nil
+}
+
+// UnmarshalJSON 支持数字(纳秒, 与 time.Duration 相同, 如: -1)和字符串(如: "1s")
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	text := string(bytes.TrimSpace(data))
+	if text == "null" {
+		return nil
+	}
+	if strings.HasPrefix(text, `"`) {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		return d.UnmarshalText([]byte(str))
+	}
+	dur, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s is invalid duration", text)
+	}
+	*d = Duration(dur)
+	return nil
+}
+
+// UnmarshalYAML 出错时带上行号
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if err := d.UnmarshalText([]byte(value.Value)); err != nil {
+		return fmt.Errorf("line %d: %v", value.Line, err)
+	}
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Config 配置, 支持 yaml, json, 如:
+//
+//	options:
+//	  tail: true
+//	writers:
+//	  out:
+//	    type: stdout
+//	handlers:
+//	  app:
+//	    tail: true
+//	    expire_at: never
+//	    merge:
+//	      type: multi
+//	      pattern: ^\[
+//	    targets:
+//	      - content: ERRO
+//	        to: [out]
+//	paths:
+//	  - path: /data/log/app.log
+//	    handler: app
+type Config struct {
+	Options  OptionsConfig             `json:"options" yaml:"options"`
+	Writers  map[string]*WriterConfig  `json:"writers" yaml:"writers"`   // 命名的 writer 实例, key: 实例名
+	Handlers map[string]*HandlerConfig `json:"handlers" yaml:"handlers"` // 命名的 handler, key: handler 名
+	Paths    []*PathConfig             `json:"paths" yaml:"paths"`       // 采集的 path
+}
+
+// OptionsConfig 对应 NewPsLog 的 Opt
+type OptionsConfig struct {
+	Tail         bool     `json:"tail" yaml:"tail"`                     // 是否调用 TailLogs 开启实时处理
+	Async2Tos    bool     `json:"async2tos" yaml:"async2tos"`           // WithAsync2Tos
+	TaskPoolSize int      `json:"task_pool_size" yaml:"task_pool_size"` // WithTaskPoolSize
+	CleanUpTime  Duration `json:"clean_up_time" yaml:"clean_up_time"`   // WithCleanUpTime
+	PollInterval Duration `json:"poll_interval" yaml:"poll_interval"`   // WithPollInterval
+	AutoPoll     bool     `json:"auto_poll" yaml:"auto_poll"`           // WithAutoPoll
+	Overflow     string   `json:"overflow" yaml:"overflow"`             // WithOverflowPolicy, 可选: block, rescan
+	TailInterval Duration `json:"tail_interval" yaml:"tail_interval"`   // WithTailInterval
+}
+
+// WriterConfig writer 实例
+type WriterConfig struct {
+	Type   string                 `json:"type" yaml:"type"`     // RegisterWriter 注册的类型
+	Params map[string]interface{} `json:"params" yaml:"params"` // 创建 writer 的参数
+}
+
+// HandlerConfig 对应 Handler
+type HandlerConfig struct {
+	LoopParse    bool              `json:"loop_parse" yaml:"loop_parse"`
+	CleanOffset  bool              `json:"clean_offset" yaml:"clean_offset"`
+	Tail         bool              `json:"tail" yaml:"tail"`
+	Poll         bool              `json:"poll" yaml:"poll"`
+	Change       int32             `json:"change" yaml:"change"`
+	ExpireDur    Duration          `json:"expire_dur" yaml:"expire_dur"`
+	ExpireAt     string            `json:"expire_at" yaml:"expire_at"` // 格式: 2006-01-02 15:04:05, never 为不过期
+	IdleExpire   bool              `json:"idle_expire" yaml:"idle_expire"`
+	Merge        *MergeConfig      `json:"merge" yaml:"merge"`
+	Decoder      string            `json:"decoder" yaml:"decoder"`             // 可选: docker, cri
+	MergeTimeout Duration          `json:"merge_timeout" yaml:"merge_timeout"` // -1 为不超时
+	Targets      []*TargetConfig   `json:"targets" yaml:"targets"`
+	Ext          string            `json:"ext" yaml:"ext"`
+	Labels       map[string]string `json:"labels" yaml:"labels"`
+	NeedCollect  []string          `json:"need_collect" yaml:"need_collect"` // path 为目录时, 文件全路径匹配其中一个正则即采集
+	Recursive    bool              `json:"recursive" yaml:"recursive"`
+	MaxDepth     int               `json:"max_depth" yaml:"max_depth"`
+}
+
+// MergeConfig 对应 line.Merger
+type MergeConfig struct {
+	Type     string `json:"type" yaml:"type"`       // 可选: single, multi, continue, end, stack, json
+	Pattern  string `json:"pattern" yaml:"pattern"` // multi 为起始行, continue 为续行, end 为结束行的正则
+	Negate   bool   `json:"negate" yaml:"negate"`
+	MaxLines int    `json:"max_lines" yaml:"max_lines"`
+	MaxBytes int    `json:"max_bytes" yaml:"max_bytes"`
+}
+
+// TargetConfig 对应 Target
+type TargetConfig struct {
+	Content  string   `json:"content" yaml:"content"`
+	Excludes []string `json:"excludes" yaml:"excludes"`
+	To       []string `json:"to" yaml:"to"` // writers 中的实例名
+	Ext      string   `json:"ext" yaml:"ext"`
+}
+
+// PathConfig 采集的 path, path 和 glob 只能填一个
+type PathConfig struct {
+	Path    string `json:"path" yaml:"path"`       // 文件或目录, 对应 AddPath2Handler
+	Glob    string `json:"glob" yaml:"glob"`       // 对应 AddGlob
+	Handler string `json:"handler" yaml:"handler"` // handlers 中的名字
+}
+
+// key 唯一标识
+func (p *PathConfig) key() string {
+	if p.Glob != "" {
+		return filepath.Clean(p.Glob)
+	}
+	return filepath.Clean(p.Path)
+}
+
+// findPath 按 key 查找 path 的配置
+func (c *Config) findPath(key string) *PathConfig {
+	for _, path := range c.Paths {
+		if path.key() == key {
+			return path
+		}
+	}
+	return nil
+}
+
+// ConfigError 配置错误, Key 为出错的配置项, 如: handlers.app.targets[0].to[1]
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (c *ConfigError) Error() string {
+	return c.Key + ": " + c.Err.Error()
+}
+
+// ConfigErrors 所有的配置错误
+type ConfigErrors []*ConfigError
+
+func (c ConfigErrors) Error() string {
+	msgs := make([]string, len(c))
+	for i, v := range c {
+		msgs[i] = v.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (c *ConfigErrors) add(key string, err error) {
+	*c = append(*c, &ConfigError{Key: key, Err: err})
+}
+
+func (c ConfigErrors) err() error {
+	if len(c) == 0 {
+		return nil
+	}
+	return c
+}
+
+// LoadConfig 加载配置文件, 根据后缀判断格式, .json 为 json, 其他为 yaml
+func LoadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile %q is failed, err: %v", filename, err)
+	}
+	format := ConfigYaml
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		format = ConfigJson
+	}
+	c, err := ParseConfig(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%q %w", filename, err)
+	}
+	return c, nil
+}
+
+// ParseConfig 解析配置并校验, format 为 ConfigYaml, ConfigJson
+// 说明: 未知的配置项会报错; 校验不通过时返回 ConfigErrors, 可通过 errors.As 获取
+func ParseConfig(data []byte, format string) (*Config, error) {
+	c := new(Config)
+	switch format {
+	case ConfigYaml:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("yaml decode is failed, err: %v", err)
+		}
+	case ConfigJson:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(c); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("json decode is failed, err: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("format %q is not supported", format)
+	}
+	if err := c.Valid(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Valid 校验配置, 不会创建 writer
+func (c *Config) Valid() error {
+	var errs ConfigErrors
+	if _, err := c.Options.overflow(); err != nil {
+		errs.add("options.overflow", err)
+	}
+	if c.Options.TaskPoolSize < 0 {
+		errs.add("options.task_pool_size", errors.New("can not less than 0"))
+	}
+
+	writers := make(map[string]PsLogWriter, len(c.Writers))
+	for _, name := range sortedKeys(c.Writers) {
+		key := "writers." + name
+		writer := c.Writers[name]
+		if writer == nil || writer.Type == "" {
+			errs.add(key+".type", errors.New("is required"))
+			continue
+		}
+		if _, ok := getWriterFactory(writer.Type); !ok {
+			errs.add(key+".type", fmt.Errorf("%q is not registered", writer.Type))
+			continue
+		}
+		writers[name] = &Stdout{} // 占位, 只用于校验 to
+	}
+
+	for _, name := range sortedKeys(c.Handlers) {
+		c.buildHandler(name, writers, &errs)
+	}
+
+	paths := make(map[string]int, len(c.Paths))
+	roots := make(map[string]int, len(c.Paths)) // glob 按不含通配符的目录, 其他按 path
+	for i, path := range c.Paths {
+		key := fmt.Sprintf("paths[%d]", i)
+		if path == nil || (path.Path == "") == (path.Glob == "") {
+			errs.add(key, errors.New("one of path, glob is required"))
+			continue
+		}
+		root := path.key()
+		if path.Glob != "" {
+			glob, err := NewGlob(path.Glob)
+			if err != nil {
+				errs.add(key+".glob", err)
+			} else {
+				root = glob.Root()
+			}
+		}
+		if j, ok := paths[path.key()]; ok {
+			errs.add(key, fmt.Errorf("%q is duplicated with paths[%d]", path.key(), j))
+		} else if j, ok := roots[root]; ok {
+			// 不同的 glob 或 glob 和 path 对应同一个目录
+			errs.add(key, fmt.Errorf("%q is conflict with paths[%d], both are %q", path.key(), j, root))
+		}
+		paths[path.key()] = i
+		if _, ok := roots[root]; !ok {
+			roots[root] = i
+		}
+		if path.Handler == "" {
+			errs.add(key+".handler", errors.New("is required"))
+		} else if _, ok := c.Handlers[path.Handler]; !ok {
+			errs.add(key+".handler", fmt.Errorf("%q is not in handlers", path.Handler))
+		}
+	}
+	return errs.err()
+}
+
+// NewPsLog 根据配置创建 PsLog, 并添加所有的 path
+// 注: 结束时需要调用 Close
+func (c *Config) NewPsLog() (*PsLog, error) {
+	writers, err := c.buildWriters()
+	if err != nil {
+		return nil, err
+	}
+	ps, err := c.newPsLog(writers)
+	if err != nil {
+		closeWriters(writers)
+		return nil, err
+	}
+	return ps, nil
+}
+
+// newPsLog 使用已创建的 writer 创建 PsLog
+func (c *Config) newPsLog(writers map[string]PsLogWriter) (*PsLog, error) {
+	opts, err := c.Options.opts()
+	if err != nil {
+		return nil, err
+	}
+	ps, err := NewPsLog(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.Options.Tail {
+		if err := ps.TailLogs(); err != nil {
+			ps.Close()
+			return nil, err
+		}
+	}
+	for i, path := range c.Paths {
+		if err := c.addPath(ps, path, writers); err != nil {
+			ps.Close()
+			return nil, &ConfigError{Key: fmt.Sprintf("paths[%d]", i), Err: err}
+		}
+	}
+	return ps, nil
+}
+
+// addPath 添加 path, 每个 path 使用独立的 handler
+func (c *Config) addPath(ps *PsLog, path *PathConfig, writers map[string]PsLogWriter) error {
+	var errs ConfigErrors
+	handler := c.buildHandler(path.Handler, writers, &errs)
+	if err := errs.err(); err != nil {
+		return err
+	}
+	if path.Glob != "" {
+		return ps.AddGlob(path.Glob, handler)
+	}
+	return ps.AddPath2Handler(path.Path, handler)
+}
+
+// buildWriters 创建所有的 writer
+func (c *Config) buildWriters() (map[string]PsLogWriter, error) {
+	writers := make(map[string]PsLogWriter, len(c.Writers))
+	for _, name := range sortedKeys(c.Writers) {
+		writer := c.Writers[name]
+		factory, ok := getWriterFactory(writer.Type)
+		if !ok {
+			return nil, &ConfigError{Key: "writers." + name + ".type", Err: fmt.Errorf("%q is not registered", writer.Type)}
+		}
+		obj, err := factory(writer.Params)
+		if err != nil {
+			return nil, &ConfigError{Key: "writers." + name, Err: err}
+		}
+		writers[name] = obj
+	}
+	return writers, nil
+}
+
+// buildHandler 根据配置创建 handler, 错误记录到 errs 中
+func (c *Config) buildHandler(name string, writers map[string]PsLogWriter, errs *ConfigErrors) *Handler {
+	key := "handlers." + name
+	hc := c.Handlers[name]
+	if hc == nil {
+		errs.add(key, errors.New("is null"))
+		return nil
+	}
+	handler := &Handler{
+		LoopParse:    hc.LoopParse,
+		CleanOffset:  hc.CleanOffset,
+		Tail:         hc.Tail,
+		Poll:         hc.Poll,
+		Change:       hc.Change,
+		ExpireDur:    time.Duration(hc.ExpireDur),
+		IdleExpire:   hc.IdleExpire,
+		MergeTimeout: time.Duration(hc.MergeTimeout),
+		Ext:          hc.Ext,
+		Labels:       hc.Labels,
+		Recursive:    hc.Recursive,
+		MaxDepth:     hc.MaxDepth,
+	}
+	errNum := len(*errs)
+
+	switch hc.ExpireAt {
+	case "":
+	case configNever:
+		handler.ExpireAt = NoExpire
+	default:
+		expireAt, err := time.ParseInLocation(configTimeLayout, hc.ExpireAt, time.Local)
+		if err != nil {
+			errs.add(key+".expire_at", fmt.Errorf("%q is not like %q or %q", hc.ExpireAt, configTimeLayout, configNever))
+		}
+		handler.ExpireAt = expireAt
+	}
+
+	if hc.Merge != nil {
+		mergeRule, err := hc.Merge.merger()
+		if err != nil {
+			errs.add(key+".merge", err)
+		}
+		handler.MergeRule = mergeRule
+	}
+
+	switch hc.Decoder {
+	case "":
+	case "docker":
+		handler.Decoder = line.NewDocker()
+	case "cri":
+		handler.Decoder = line.NewCRI()
+	default:
+		errs.add(key+".decoder", fmt.Errorf("%q is not supported, optional: docker, cri", hc.Decoder))
+	}
+
+	if len(hc.NeedCollect) > 0 {
+		res, err := compilePatterns(hc.NeedCollect)
+		if err != nil {
+			errs.add(key+".need_collect", err)
+		}
+		handler.NeedCollect = func(filename string) bool {
+			return matchPatterns(res, filename)
+		}
+	}
+
+	for i, tc := range hc.Targets {
+		targetKey := fmt.Sprintf("%s.targets[%d]", key, i)
+		if tc == nil || tc.Content == "" {
+			errs.add(targetKey+".content", errors.New("is required"))
+			continue
+		}
+		if len(tc.To) == 0 {
+			errs.add(targetKey+".to", errors.New("is required"))
+			continue
+		}
+		target := &Target{Content: tc.Content, Excludes: tc.Excludes, Ext: tc.Ext}
+		for j, to := range tc.To {
+			writer, ok := writers[to]
+			if !ok {
+				errs.add(fmt.Sprintf("%s.to[%d]", targetKey, j), fmt.Errorf("%q is not in writers", to))
+				continue
+			}
+			target.To = append(target.To, writer)
+		}
+		handler.Targets = append(handler.Targets, target)
+	}
+
+	// 其他的交给 Handler.Valid
+	if len(*errs) == errNum {
+		if err := handler.Valid(); err != nil {
+			errs.add(key, err)
+		}
+	}
+	return handler
+}
+
+// mergeRule 可配置的合并规则
+type mergeRule interface {
+	line.Merger
+	Negate(negate bool)
+	MaxLines(n int)
+	MaxBytes(n int)
+}
+
+// merger 根据配置创建 line.Merger
+func (m *MergeConfig) merger() (line.Merger, error) {
+	var (
+		res     mergeRule
+		compile func(expr string) error // 需要 pattern 的规则
+	)
+	switch m.Type {
+	case "", "single":
+		return line.NewSing(), nil
+	case "multi":
+		tmp := line.NewMulti()
+		res, compile = tmp, tmp.StartPattern
+	case "continue":
+		tmp := line.NewContinue()
+		res, compile = tmp, tmp.ContinuePattern
+	case "end":
+		tmp := line.NewEnd()
+		res, compile = tmp, tmp.EndPattern
+	case "stack":
+		res = line.NewStack()
+	case "json":
+		res = line.NewJSON()
+	default:
+		return nil, fmt.Errorf("type %q is not supported, optional: single, multi, continue, end, stack, json", m.Type)
+	}
+
+	if compile != nil {
+		if m.Pattern == "" {
+			return nil, fmt.Errorf("pattern is required when type is %q", m.Type)
+		}
+		if err := compile(m.Pattern); err != nil {
+			return nil, fmt.Errorf("pattern %q is not ok, err: %v", m.Pattern, err)
+		}
+	}
+	res.Negate(m.Negate)
+	if m.MaxLines > 0 {
+		res.MaxLines(m.MaxLines)
+	}
+	if m.MaxBytes > 0 {
+		res.MaxBytes(m.MaxBytes)
+	}
+	return res, nil
+}
+
+// overflow 转换为 OverflowPolicy
+func (o *OptionsConfig) overflow() (OverflowPolicy, error) {
+	switch o.Overflow {
+	case "", "block":
+		return OverflowBlock, nil
+	case "rescan":
+		return OverflowRescan, nil
+	}
+	return 0, fmt.Errorf("%q is not supported, optional: block, rescan", o.Overflow)
+}
+
+// opts 转换为 NewPsLog 的 Opt
+func (o *OptionsConfig) opts() ([]Opt, error) {
+	policy, err := o.overflow()
+	if err != nil {
+		return nil, &ConfigError{Key: "options.overflow", Err: err}
+	}
+	opts := []Opt{WithOverflowPolicy(policy)}
+	if o.Async2Tos {
+		opts = append(opts, WithAsync2Tos())
+	}
+	if o.TaskPoolSize > 0 {
+		opts = append(opts, WithTaskPoolSize(o.TaskPoolSize))
+	}
+	if o.CleanUpTime > 0 {
+		opts = append(opts, WithCleanUpTime(time.Duration(o.CleanUpTime)))
+	}
+	if o.PollInterval > 0 {
+		opts = append(opts, WithPollInterval(time.Duration(o.PollInterval)))
+	}
+	if o.AutoPoll {
+		opts = append(opts, WithAutoPoll())
+	}
+	if o.TailInterval > 0 {
+		opts = append(opts, WithTailInterval(time.Duration(o.TailInterval)))
+	}
+	return opts, nil
+}
+
+// sortedKeys 排序的 key, 保证错误的顺序固定
+func sortedKeys(m interface{}) []string {
+	var res []string
+	switch v := m.(type) {
+	case map[string]*WriterConfig:
+		for key := range v {
+			res = append(res, key)
+		}
+	case map[string]*HandlerConfig:
+		for key := range v {
+			res = append(res, key)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,294 @@
+package pslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gitee.com/xuesongtao/gotool/xfile"
+	"gitee.com/xuesongtao/ps-log/line"
+)
+
+func TestParseConfig(t *testing.T) {
+	data := `
+options:
+  tail: true
+  task_pool_size: 5
+  poll_interval: 500ms
+  overflow: rescan
+writers:
+  out:
+    type: stdout
+handlers:
+  app:
+    tail: true
+    change: -1
+    expire_at: never
+    decoder: docker
+    merge_timeout: 1s
+    merge:
+      type: multi
+      pattern: ^\[
+      max_lines: 10
+    labels:
+      env: test
+    targets:
+      - content: ERRO
+        excludes: [ignore]
+        to: [out]
+  dir:
+    expire_dur: 1h
+    need_collect: ['\.log$']
+    recursive: true
+    merge:
+      type: stack
+    targets:
+      - content: panic
+        to: [out]
+paths:
+  - path: /data/log/app.log
+    handler: app
+  - glob: /data/*/log/*.log
+    handler: dir
+`
+	c, err := ParseConfig([]byte(data), ConfigYaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Options.Tail || c.Options.TaskPoolSize != 5 || time.Duration(c.Options.PollInterval) != 500*time.Millisecond {
+		t.Errorf("options: %+v", c.Options)
+	}
+	if len(c.Paths) != 2 || c.Paths[1].Glob != "/data/*/log/*.log" {
+		t.Errorf("paths: %+v", c.Paths)
+	}
+
+	var errs ConfigErrors
+	handler := c.buildHandler("app", map[string]PsLogWriter{"out": &Stdout{}}, &errs)
+	if errs.err() != nil {
+		t.Fatal(errs)
+	}
+	if handler.ExpireAt != NoExpire || handler.Change != -1 || handler.MergeTimeout != time.Second {
+		t.Errorf("handler: %+v", handler)
+	}
+	if _, ok := handler.Decoder.(*line.Docker); !ok {
+		t.Errorf("decoder: %T", handler.Decoder)
+	}
+	if _, ok := handler.MergeRule.(*line.Multi); !ok {
+		t.Errorf("merge: %T", handler.MergeRule)
+	}
+	if len(handler.Targets) != 1 || !reflect.DeepEqual(handler.Targets[0].Excludes, []string{"ignore"}) {
+		t.Errorf("targets: %+v", handler.Targets)
+	}
+
+	handler = c.buildHandler("dir", map[string]PsLogWriter{"out": &Stdout{}}, &errs)
+	if errs.err() != nil {
+		t.Fatal(errs)
+	}
+	if handler.NeedCollect == nil || !handler.NeedCollect("/data/a/log/app.log") || handler.NeedCollect("/data/a/log/app.txt") {
+		t.Error("need_collect is not ok")
+	}
+
+	// json
+	data = `{"writers": {"out": {"type": "stdout"}}, "handlers": {"app": {"expire_dur": "1h", "targets": [{"content": "ERRO", "to": ["out"]}]}}, "paths": [{"path": "/data/log/app.log", "handler": "app"}]}`
+	if _, err := ParseConfig([]byte(data), ConfigJson); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	data := `
+options:
+  overflow: drop
+writers:
+  out:
+    type: kafka
+  out2: {}
+handlers:
+  app:
+    expire_at: tomorrow
+    decoder: xml
+    merge:
+      type: multi
+    need_collect: ['(']
+    targets:
+      - content: ERRO
+        to: [out, none]
+      - to: [out]
+  empty:
+    tail: true
+paths:
+  - path: /data/log/app.log
+    handler: app
+  - path: /data/log/app.log/
+    handler: app
+  - glob: /data/log/[.log
+    handler: missing
+  - path: /data/log/a.log
+    glob: /data/*.log
+    handler: app
+  - path: /data/app
+    handler: app
+  - glob: /data/app/*.log
+    handler: app
+  - glob: /data/app/*/*.log
+    handler: app
+`
+	_, err := ParseConfig([]byte(data), ConfigYaml)
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("err: %v", err)
+	}
+	keys := make([]string, 0, len(errs))
+	for _, v := range errs {
+		keys = append(keys, v.Key)
+	}
+	want := []string{
+		"options.overflow",
+		"writers.out.type",
+		"writers.out2.type",
+		"handlers.app.expire_at",
+		"handlers.app.merge",
+		"handlers.app.decoder",
+		"handlers.app.need_collect",
+		"handlers.app.targets[0].to[0]",
+		"handlers.app.targets[0].to[1]",
+		"handlers.app.targets[1].content",
+		"handlers.empty",
+		"paths[1]",
+		"paths[2].glob",
+		"paths[2].handler",
+		"paths[3]",
+		"paths[5]",
+		"paths[6]",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys: %v\nerr: %v", keys, err)
+	}
+
+	// 未知的配置项
+	_, err = ParseConfig([]byte("handlers:\n  app:\n    tails: true\n"), ConfigYaml)
+	if err == nil || !strings.Contains(err.Error(), "tails") {
+		t.Errorf("err: %v", err)
+	}
+	_, err = ParseConfig([]byte(`{"options": {"poll_interval": "1x"}}`), ConfigJson)
+	if err == nil {
+		t.Error("poll_interval should is not ok")
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	tests := []struct {
+		data string
+		dur  time.Duration
+	}{
+		{`1500000000`, 1500 * time.Millisecond},
+		{`-1`, -1},
+		{`"2m"`, 2 * time.Minute},
+		{`"-1"`, -1},
+		{`0`, 0},
+	}
+	for _, tt := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(tt.data), &d); err != nil {
+			t.Errorf("%s err: %v", tt.data, err)
+			continue
+		}
+		if time.Duration(d) != tt.dur {
+			t.Errorf("%s got: %v", tt.data, time.Duration(d))
+		}
+	}
+
+	for _, data := range []string{`"1x"`, `1.5`, `true`} {
+		var d Duration
+		if err := json.Unmarshal([]byte(data), &d); err == nil {
+			t.Errorf("%s should be invalid", data)
+		}
+	}
+
+	var c Config
+	if err := json.Unmarshal([]byte(`{"options": {"poll_interval": 500000000}, "handlers": {"app": {"merge_timeout": -1}}}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(c.Options.PollInterval) != 500*time.Millisecond || c.Handlers["app"].MergeTimeout != -1 {
+		t.Errorf("poll_interval: %v, merge_timeout: %v", c.Options.PollInterval, c.Handlers["app"].MergeTimeout)
+	}
+}
+
+func TestConfigNewPsLog(t *testing.T) {
+	strBuf := new(StrBuf)
+	RegisterWriter("test", func(params map[string]interface{}) (PsLogWriter, error) {
+		return strBuf, nil
+	})
+
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "app.log")
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.log")
+	data := fmt.Sprintf(`
+options:
+  tail: true
+writers:
+  buf:
+    type: test
+  file:
+    type: file
+    params:
+      path: %s
+handlers:
+  app:
+    tail: true
+    change: -1
+    expire_at: never
+    merge:
+      type: multi
+      pattern: ^\[
+    targets:
+      - content: ERRO
+        to: [buf, file]
+paths:
+  - path: %s
+    handler: app
+`, out, tmp)
+	filename := filepath.Join(dir, "pslog.yaml")
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := c.NewPsLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	if _, err := xfile.AppendContent(tmp, "[ERRO] test\nstack\n[INFO] ok\n"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if got := strBuf.String(); strings.Count(got, "[ERRO] test\nstack") != 1 || strings.Contains(got, "INFO") {
+		t.Errorf("got: %q", got)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "[ERRO] test\nstack") {
+		t.Errorf("file: %q", content)
+	}
+
+	// writer 创建失败
+	c.Writers["file"].Params = nil
+	if _, err := c.NewPsLog(); err == nil || !strings.HasPrefix(err.Error(), "writers.file:") {
+		t.Errorf("err: %v", err)
+	}
+}
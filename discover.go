@@ -0,0 +1,191 @@
+package pslog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	plg "gitee.com/xuesongtao/ps-log/log"
+)
+
+// SrcWatcher 持续发现 src 下的项目, 新增的项目自动添加到 PsLog, 删除的项目自动移除
+// 说明:
+//  1. 项目中 log 目录或目标文件还不存在时也会添加, 等创建后再采集
+//  2. 每个命名空间可以设置 handler 模板, 每个 log path 使用模板的副本; 没有设置时按 PsLog 的 handler 来处理
+//  3. LogHandlerBus.Labels 中会带上 project, namespace 及 LogPath 设置的标签
+//  4. LogDir.TargetPatterns 不为空时会采集整个 log 目录中匹配的文件, 新出现的也会采集, 注: NewestOnly 不生效
+type SrcWatcher struct {
+	logPath           *LogPath
+	ps                *PsLog
+	namespace2Handler map[string]*Handler // key: LogDir.Namespace
+	mu                sync.Mutex
+	srcMap            map[string]*ProjectSrc   // key: SrcPath
+	projectMap        map[string][]*ProjectLog // 已添加的项目, key: 项目 path
+	watch             *Watch
+	closeOnce         sync.Once
+}
+
+// NewSrcWatcher 持续发现项目
+// namespace2Handler 为每个命名空间的 handler 模板, key: LogDir.Namespace
+func (l *LogPath) NewSrcWatcher(ps *PsLog, namespace2Handler map[string]*Handler) (*SrcWatcher, error) {
+	if ps == nil {
+		return nil, errors.New("ps is nil")
+	}
+	watch, err := NewWatch(ps.watchOpts...)
+	if err != nil {
+		return nil, err
+	}
+	obj := &SrcWatcher{
+		logPath:           l,
+		ps:                ps,
+		namespace2Handler: namespace2Handler,
+		srcMap:            make(map[string]*ProjectSrc),
+		projectMap:        make(map[string][]*ProjectLog),
+		watch:             watch,
+	}
+	busCh := make(chan *WatchFileInfo, 1<<4)
+	watch.Watch(busCh)
+	go obj.run(busCh)
+	return obj, nil
+}
+
+// AddSrc 添加需要发现项目的 src, 会先添加已有的项目, 注意: src.SrcPath 必须为绝对路径
+func (s *SrcWatcher) AddSrc(srcs ...*ProjectSrc) error {
+	for _, src := range srcs {
+		if src == nil || src.LogDir == nil {
+			return errors.New("src.LogDir is nil")
+		}
+		src.LogDir.init()
+		if err := src.LogDir.valid(); err != nil {
+			return fmt.Errorf("%q LogDir is not ok, err: %v", src.SrcPath, err)
+		}
+		src.SrcPath = filepath.Clean(src.SrcPath)
+
+		s.mu.Lock()
+		s.srcMap[src.SrcPath] = src
+		s.mu.Unlock()
+		if err := s.watch.Add(src.SrcPath); err != nil {
+			return fmt.Errorf("s.watch.Add is failed, err: %v", err)
+		}
+		s.sync(src)
+	}
+	return nil
+}
+
+// Projects 已添加的项目 log
+func (s *SrcWatcher) Projects() []*ProjectLog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*ProjectLog, 0, len(s.projectMap))
+	for _, logs := range s.projectMap {
+		res = append(res, logs...)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].LogPath < res[j].LogPath })
+	return res
+}
+
+// Close 停止发现, 已添加的 path 不会移除
+func (s *SrcWatcher) Close() {
+	s.closeOnce.Do(s.watch.Close)
+}
+
+func (s *SrcWatcher) run(busCh chan *WatchFileInfo) {
+	for {
+		select {
+		case watchInfo, ok := <-busCh:
+			if !ok {
+				plg.Info("src watcher is close")
+				return
+			}
+			s.mu.Lock()
+			src := s.srcMap[watchInfo.Path]
+			s.mu.Unlock()
+			if src != nil {
+				s.sync(src)
+			}
+		case <-s.watch.RescanCh():
+			s.mu.Lock()
+			srcs := make([]*ProjectSrc, 0, len(s.srcMap))
+			for _, src := range s.srcMap {
+				srcs = append(srcs, src)
+			}
+			s.mu.Unlock()
+			for _, src := range srcs {
+				s.sync(src)
+			}
+		}
+	}
+}
+
+// sync 对比 src 下的项目, 添加新增的, 移除删除的
+func (s *SrcWatcher) sync(src *ProjectSrc) {
+	entries, err := os.ReadDir(src.SrcPath)
+	if err != nil && !os.IsNotExist(err) {
+		plg.Errorf("os.ReadDir %q is failed, err: %v", src.SrcPath, err)
+		return
+	}
+	exists := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		projectPath := filepath.Join(src.SrcPath, entry.Name())
+		if !entry.IsDir() || s.logPath.excludePath(projectPath) {
+			continue
+		}
+		exists[projectPath] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for projectPath := range exists {
+		if _, ok := s.projectMap[projectPath]; ok {
+			continue
+		}
+		s.projectMap[projectPath] = s.addProject(src, projectPath)
+	}
+	for projectPath, logs := range s.projectMap {
+		if filepath.Dir(projectPath) != src.SrcPath || exists[projectPath] {
+			continue
+		}
+		s.removeProject(projectPath, logs)
+		delete(s.projectMap, projectPath)
+	}
+}
+
+// addProject 添加项目的目标文件, 调用方需加锁
+func (s *SrcWatcher) addProject(src *ProjectSrc, projectPath string) []*ProjectLog {
+	logs := make([]*ProjectLog, 0, len(src.LogDir.TargetNames))
+	for _, targetName := range src.LogDir.TargetNames {
+		projectLog := s.logPath.newProjectLog(&Project{LogDir: src.LogDir, ProjectPath: projectPath}, targetName)
+		if err := s.ps.AddProjectLogs(s.namespace2Handler[projectLog.Namespace], projectLog); err != nil {
+			plg.Errorf("AddProjectLogs %q is failed, err: %v", projectLog.LogPath, err)
+			continue
+		}
+		logs = append(logs, projectLog)
+	}
+
+	// 按正则匹配的文件, 采集整个 log 目录, 新出现的匹配文件也会采集
+	if len(src.LogDir.targets) > 0 {
+		projectLog := s.logPath.newProjectLog(&Project{LogDir: src.LogDir, ProjectPath: projectPath}, "")
+		projectLog.needCollect = src.LogDir.matchTarget
+		if err := s.ps.AddProjectLogs(s.namespace2Handler[projectLog.Namespace], projectLog); err != nil {
+			plg.Errorf("AddProjectLogs %q is failed, err: %v", projectLog.LogPath, err)
+		} else {
+			logs = append(logs, projectLog)
+		}
+	}
+	plg.Infof("project %q is added", projectPath)
+	return logs
+}
+
+// removeProject 移除项目的目标文件
+// 说明: 项目已删除, 同时清理保存的偏移量, 防止持久化偏移量时重新创建项目目录
+func (s *SrcWatcher) removeProject(projectPath string, logs []*ProjectLog) {
+	for _, projectLog := range logs {
+		if err := s.ps.RemovePath(projectLog.LogPath, true); err != nil {
+			plg.Warningf("RemovePath %q is failed, err: %v", projectLog.LogPath, err)
+		}
+	}
+	plg.Infof("project %q is removed", projectPath)
+}
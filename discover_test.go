@@ -0,0 +1,151 @@
+package pslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitee.com/xuesongtao/gotool/xfile"
+)
+
+func TestSrcWatcher(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	src := t.TempDir()
+	watcher, err := NewLogPath().NewSrcWatcher(ps, map[string]*Handler{
+		"test": {
+			Change:   -1,       // 每次都持久化 offset
+			Tail:     true,     // 实时监听
+			ExpireAt: NoExpire, // 文件句柄不过期
+			Targets: []*Target{
+				{
+					Content: "warning",
+					To:      []PsLogWriter{strBuf},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	// 已有的项目
+	demo1 := filepath.Join(src, "demo1", "log", "app.log")
+	if err := os.MkdirAll(filepath.Dir(demo1), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(demo1, ""); err != nil {
+		t.Fatal(err)
+	}
+	err = watcher.AddSrc(&ProjectSrc{
+		LogDir:  &LogDir{Namespace: "test", TargetNames: []string{"app.log"}},
+		SrcPath: src,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 新增的项目
+	demo2 := filepath.Join(src, "demo2", "log", "app.log")
+	if err := os.MkdirAll(filepath.Dir(demo2), 0755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return len(watcher.Projects()) == 2 })
+	for _, name := range []string{demo1, demo2} {
+		if _, err := xfile.AppendContent(name, "warning\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool { return strings.Count(strBuf.String(), "warning") == 2 })
+
+	// 删除的项目
+	if err := os.RemoveAll(filepath.Join(src, "demo1")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return len(watcher.Projects()) == 1 })
+	projects := watcher.Projects()
+	if len(projects) != 1 || projects[0].ProjectName != "demo2" {
+		t.Errorf("projects: %+v", projects)
+	}
+	ps.rwMu.RLock()
+	_, ok := ps.logMap[demo1]
+	ps.rwMu.RUnlock()
+	if ok {
+		t.Error("demo1 should is removed")
+	}
+}
+
+func TestSrcWatcherPattern(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "demo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := NewLogPath().NewSrcWatcher(ps, map[string]*Handler{
+		"test": {
+			Change:   -1,       // 每次都持久化 offset
+			Tail:     true,     // 实时监听
+			ExpireAt: NoExpire, // 文件句柄不过期
+			Targets: []*Target{
+				{
+					Content: "warning",
+					To:      []PsLogWriter{strBuf},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	err = watcher.AddSrc(&ProjectSrc{
+		LogDir: &LogDir{
+			Namespace:       "test",
+			TargetPatterns:  []string{`^app-\d{8}\.log$`},
+			ExcludePatterns: []string{`^app-2025`},
+		},
+		SrcPath: src,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// log 目录及按日期的文件后创建
+	logDir := filepath.Join(src, "demo", "log")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return watching(ps.watch, logDir) })
+	// 匹配的最后写入, 解析完时不匹配的事件已处理
+	names := []string{"app-20251017.log", "app.log", "app-20261017.log"}
+	for _, name := range names {
+		if _, err := xfile.AppendContent(filepath.Join(logDir, name), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	matched := filepath.Join(logDir, "app-20261017.log")
+	waitFor(t, time.Second, func() bool { return parsed(ps, matched) })
+	for _, name := range names {
+		if _, err := xfile.AppendContent(filepath.Join(logDir, name), "warning "+name+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, matched) })
+	if got := strBuf.String(); strings.Count(got, "warning") != 1 || !strings.Contains(got, "app-20261017.log") {
+		t.Errorf("got: %q", got)
+	}
+}
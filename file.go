@@ -29,15 +29,25 @@ type FileInfo struct {
 	mu                  sync.Mutex
 	watchChangeFilename string
 	filename            string
+	removed             bool // 是否已被移除, 移除后不再解析
 
 	// 父级特有参数
 	op       fs.Op
-	children map[string]*FileInfo // 如果当前为目录的时候, 这里就有值, key: 文件名[这里不是全路径]
+	children map[string]*FileInfo // 如果当前为目录的时候, 这里就有值, key: 文件相对目录的路径[这里不是全路径]
+	excluded sync.Map             // 通过 RemovePath 移除的文件, 不再采集, key: 文件全路径
 
 	// 子级特有参数
 	fh           *os.File      // 存放的文件句柄, 只有 可读权限, key: filename
 	reader       *bufio.Reader // fh 读
 	offsetChange int32         // 记录 offset 变化次数
+	tailPending  int32         // 是否已有待处理的实时解析, 1-是
+	lastTail     int64         // 上一次实时解析的时间, 单位: 纳秒
+	activeAt     int64         // 最近一次有解析的时间, 用于 Handler.IdleExpire, 单位: 纳秒
+	mergeSize    int64         // 已读取但还在合并中的内容长度, 持久化的偏移量不含这部分, 重启后会重新读取
+	mergeTimer   *time.Timer   // 合并中的内容超时输出的定时器
+	taskMu       sync.Mutex    // 保护 tasks, taskRunning
+	tasks        []func()      // 实时处理的任务, 按顺序串行处理
+	taskRunning  bool          // 是否已在 taskPool 中处理 tasks
 	offset       int64         // 当前文件偏移量
 	beginOffset  int64         // 记录最开始的偏移量
 }
@@ -56,6 +66,7 @@ func NewFileInfo(path string, handler *Handler) (*FileInfo, error) {
 		offsetChange:        0,
 		offset:              0,
 		beginOffset:         0,
+		activeAt:            time.Now().UnixNano(),
 	}
 
 	if !handler.initd {
@@ -71,27 +82,77 @@ func NewFileInfo(path string, handler *Handler) (*FileInfo, error) {
 func (f *FileInfo) init() error {
 	// fmt.Println("-----", f.Handler.path, f.IsDir())
 	f.Parse(f.Handler.path)
+	if f.IsPending() {
+		return nil
+	}
 	if !f.IsDir() {
-		f.initFh()
+		// 先校验保存的偏移量, 打开句柄时会保存当前文件的标识
 		f.initOffset()
+		f.initFh()
 		return nil
 	}
 
 	// 如果是目录的话需要初始化 children
-	return f.loopDir(f.Handler.path, func(info os.FileInfo) error {
-		filename := filepath.Join(f.Dir, info.Name())
+	return f.initChildren()
+}
+
+// initChildren 初始化目录下需要采集的文件
+func (f *FileInfo) initChildren() error {
+	return f.walkDir(f.Handler.path, func(filename string, info os.FileInfo) error {
 		// fmt.Println("=====",filename)
 		if f.needCollect(filename) {
-			tmp, err := NewFileInfo(filename, f.Handler.copy())
+			tmp, err := NewFileInfo(filename, f.Handler.copyFor(filename))
 			if err != nil {
 				return err
 			}
-			f.children[info.Name()] = tmp
+			f.children[f.childKey(filename)] = tmp
 		}
 		return nil
 	})
 }
 
+// activate 注册时还不存在的 path 出现后进行初始化, 返回 path 是否已存在
+// 说明: 按 Handler.CleanOffset 处理之前保存的偏移量, 保存的偏移量不属于当前的文件时从头开始采集
+func (f *FileInfo) activate() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.IsPending() {
+		return true, nil
+	}
+
+	st, err := os.Stat(f.Handler.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("os.Stat %q is failed, err: %v", f.Handler.path, err)
+	}
+	if st.IsDir() && f.Handler.NeedCollect == nil {
+		return false, fmt.Errorf("%q is dir, NeedCollect is nil", f.Handler.path)
+	}
+	f.Handler.isDir = st.IsDir()
+	f.Handler.pending = false
+	f.filename = ""
+	f.Parse(f.Handler.path)
+	if f.IsDir() {
+		return true, f.initChildren()
+	}
+
+	f.initOffset()
+	return true, f.initFh()
+}
+
+// childList 目录下需要采集的文件
+func (f *FileInfo) childList() []*FileInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := make([]*FileInfo, 0, len(f.children))
+	for _, child := range f.children {
+		res = append(res, child)
+	}
+	return res
+}
+
 func isRename(op fs.Op) bool {
 	return op.Has(fs.Rename)
 }
@@ -100,6 +161,16 @@ func isCreate(op fs.Op) bool {
 	return op.Has(fs.Create)
 }
 
+func isRemove(op fs.Op) bool {
+	return op.Has(fs.Remove)
+}
+
+// isDirPath path 是否为已存在的目录
+func isDirPath(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
+
 func (f *FileInfo) initFh() error {
 	if f.fh != nil {
 		return nil
@@ -111,6 +182,7 @@ func (f *FileInfo) initFh() error {
 	}
 	f.fh = ff
 	f.reader = bufio.NewReader(f.fh)
+	f.saveFileID()
 	return nil
 }
 
@@ -142,14 +214,166 @@ func (f *FileInfo) ScanLinesOfInCr(fn func(row []byte) error) (int64, error) {
 func (f *FileInfo) resetFn() {
 	f.closeFileHandle()
 	f.offset = 0
+	f.resetMerge()
 	f.saveOffset(true)
 }
 
+// resetMerge 丢弃解码, 合并中的内容
+func (f *FileInfo) resetMerge() {
+	for _, handler := range f.Handler.handlers() {
+		handler.resetState()
+	}
+	atomic.StoreInt64(&f.mergeSize, 0)
+}
+
+// clean 关闭句柄, 清理偏移量, 调用方需加锁
+func (f *FileInfo) clean() {
+	f.closeFileHandle()
+	f.resetMerge()
+	f.offset = 0
+	f.beginOffset = 0
+	f.offsetChange = 0
+	f.removeOffsetFile(f.offsetFilename())
+	f.removeOffsetFile(f.idFilename())
+}
+
+// deactivate path 被删除后, 关闭句柄, 清理偏移量, 等重新创建后再采集
+// 说明: 目录下的文件需先通过 detachChild 移除
+func (f *FileInfo) deactivate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.IsDir() {
+		f.clean()
+	}
+	f.Handler.pending = true
+}
+
+// detachChild 目录下的文件或子目录被删除/重命名后, 从 children 中移除, 返回被移除的文件
+// 说明: 被移除的文件已提交的任务可能还未处理, 由调用方通过文件的任务队列关闭
+func (f *FileInfo) detachChild(filename string) []*FileInfo {
+	if !f.IsDir() {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := f.childKey(filename)
+	res := make([]*FileInfo, 0, 1)
+	for name, child := range f.children {
+		// 子目录被删除时, 移除其下所有的文件; 目录本身被删除时, 移除所有的文件
+		if key != "." && name != key && !strings.HasPrefix(name, key+string(filepath.Separator)) {
+			continue
+		}
+		res = append(res, child)
+		delete(f.children, name)
+	}
+	return res
+}
+
+// hasChild 目录下是否已有该文件
+func (f *FileInfo) hasChild(filename string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.children[f.childKey(filename)]
+	return ok
+}
+
+// close 移除时, 等正在进行的解析完成后, 保存偏移量, 关闭句柄; cleanOffset 为 true 时删除保存的偏移量
+func (f *FileInfo) close(cleanOffset bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = true
+	if f.mergeTimer != nil {
+		f.mergeTimer.Stop()
+	}
+	if f.IsDir() {
+		for name, child := range f.children {
+			child.close(cleanOffset)
+			delete(f.children, name)
+		}
+		return
+	}
+	if f.IsPending() {
+		return
+	}
+
+	if cleanOffset {
+		f.removeOffsetFile(f.offsetFilename())
+		f.removeOffsetFile(f.idFilename())
+	} else {
+		f.saveOffset(true)
+		f.remove(f.offsetFilename())
+		f.remove(f.idFilename())
+	}
+	f.closeFileHandle()
+}
+
+// inDir 文件是否在当前目录下
+func (f *FileInfo) inDir(filename string) bool {
+	if !f.IsDir() {
+		return false
+	}
+	rel, err := filepath.Rel(f.Dir, filename)
+	return err == nil && rel != "." && !strings.HasPrefix(rel, "..")
+}
+
+// excludeChild 移除目录下的文件, 之后不再采集, 返回被移除的文件
+// 说明: 被移除的文件由调用方通过文件的任务队列关闭
+func (f *FileInfo) excludeChild(filename string) *FileInfo {
+	f.excluded.Store(filename, true)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := f.childKey(filename)
+	child := f.children[key]
+	delete(f.children, key)
+	return child
+}
+
 // Extension 延期
 func (f *FileInfo) Extension() {
 	f.Handler.ExpireAt = time.Now().Add(f.Handler.ExpireDur)
 }
 
+// attach 附加 handler, 目录时同时附加到已有的需要采集的文件
+func (f *FileInfo) attach(handler *Handler) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Handler.attached = append(f.Handler.attached, handler)
+	if !f.IsDir() {
+		return nil
+	}
+
+	for _, child := range f.children {
+		filename := child.FileName()
+		if !handler.NeedCollect(filename) {
+			continue
+		}
+		tmp := handler.copyFor(filename)
+		tmp.path = filename
+		if err := tmp.init(); err != nil {
+			return err
+		}
+		child.mu.Lock()
+		child.Handler.attached = append(child.Handler.attached, tmp)
+		child.mu.Unlock()
+	}
+	return nil
+}
+
+// active 记录活跃时间, 空闲过期时会延期
+func (f *FileInfo) active() {
+	atomic.StoreInt64(&f.activeAt, time.Now().UnixNano())
+}
+
+// ExpireAt 过期时间
+func (f *FileInfo) ExpireAt() time.Time {
+	if f.Handler.IdleExpire {
+		return time.Unix(0, atomic.LoadInt64(&f.activeAt)).Add(f.Handler.ExpireDur)
+	}
+	return f.Handler.ExpireAt
+}
+
 // getFileInfo 根据文件全路径名获取 FileInfo
 func (f *FileInfo) getFileInfo(filename string) (*FileInfo, error) {
 	// fmt.Println("getFileInfo:", filename, f.FileName())
@@ -160,19 +384,28 @@ func (f *FileInfo) getFileInfo(filename string) (*FileInfo, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	// FileInfo 为目录
-	basename := filepath.Base(filename)
-	tmp, ok := f.children[basename]
+	key := f.childKey(filename)
+	tmp, ok := f.children[key]
 	if ok {
 		return tmp, nil
 	}
-	tmp, err := NewFileInfo(filename, f.Handler.copy())
+	tmp, err := NewFileInfo(filename, f.Handler.copyFor(filename))
 	if err != nil {
 		return nil, err
 	}
-	f.children[basename] = tmp
+	f.children[key] = tmp
 	return tmp, nil
 }
 
+// childKey children 的 key, 为文件相对目录的路径, 非递归时即为文件名
+func (f *FileInfo) childKey(filename string) string {
+	rel, err := filepath.Rel(f.Dir, filename)
+	if err != nil {
+		return filepath.Base(filename)
+	}
+	return rel
+}
+
 // HandlerIsNil
 func (f *FileInfo) HandlerIsNil() bool {
 	return f.Handler == nil
@@ -211,12 +444,52 @@ func (f *FileInfo) expireClose() {
 	}
 }
 
+// idleClose 关闭空闲过期的句柄, 不移除, 返回关闭的文件
+func (f *FileInfo) idleClose(t time.Time) []string {
+	if !f.IsDir() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.fh == nil || !f.IsExpire(t) {
+			return nil
+		}
+		f.saveOffset(true)
+		f.closeFileHandle()
+		return []string{f.FileName()}
+	}
+
+	var closed []string
+	for _, fileInfo := range f.childList() {
+		closed = append(closed, fileInfo.idleClose(t)...)
+	}
+	return closed
+}
+
+// flushOffset 保存打开的文件的偏移量, 用于退出时, 合并中的内容不计入, 下次启动会重新采集
+func (f *FileInfo) flushOffset() {
+	if !f.IsDir() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.removed || f.fh == nil {
+			return
+		}
+		f.saveOffset(true)
+		return
+	}
+
+	for _, fileInfo := range f.childList() {
+		fileInfo.flushOffset()
+	}
+}
+
 // NeedCollect 判断下是否需要被采集
 func (f *FileInfo) needCollect(filename string) bool {
 	if f.HandlerIsNil() {
 		return false
 	}
-	return f.Handler.NeedCollect(filename)
+	if _, ok := f.excluded.Load(filename); ok {
+		return false
+	}
+	return f.Handler.needCollect(filename)
 }
 
 // IsDir 是否为目录
@@ -224,6 +497,11 @@ func (f *FileInfo) IsDir() bool {
 	return f.Handler.isDir
 }
 
+// IsPending path 是否还不存在
+func (f *FileInfo) IsPending() bool {
+	return f.Handler.pending
+}
+
 // FileName 获取文件的全路径名
 func (f *FileInfo) FileName() string {
 	if f.filename != "" {
@@ -249,7 +527,7 @@ func (f *FileInfo) IsExpire(t ...time.Time) bool {
 		defaultTime = t[0]
 	}
 	if !f.HandlerIsNil() {
-		return f.Handler.ExpireAt.Before(defaultTime)
+		return f.ExpireAt().Before(defaultTime)
 	}
 	return false
 }
@@ -287,10 +565,54 @@ func (f *FileInfo) storeOffset(o int64) {
 	atomic.StoreInt64(&f.offset, o)
 }
 
+// loadPending 是否还不存在, 用于未持有 f.mu 的调用方
+func (f *FileInfo) loadPending() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.IsPending()
+}
+
 func (f *FileInfo) loadOffset() int64 {
 	return atomic.LoadInt64(&f.offset)
 }
 
+// idFilename 保存文件标识的文件名
+func (f *FileInfo) idFilename() string {
+	return f.offsetFilename("id")
+}
+
+// saveFileID 打开句柄后保存文件标识
+func (f *FileInfo) saveFileID() {
+	st, err := f.fh.Stat()
+	if err != nil {
+		return
+	}
+	if id := fileID(st); id != "" {
+		if _, err := f.putContent(f.idFilename(), id); err != nil {
+			plg.Error("f.putContent is failed, err:", err)
+		}
+	}
+}
+
+// sameFile 保存的偏移量是否属于当前的文件, 如: 轮转后同名的新文件不属于
+// 说明: 按保存的文件标识(设备号和 inode)判断, 没有保存或不支持时按偏移量不超过文件大小判断
+func (f *FileInfo) sameFile(st os.FileInfo) bool {
+	if st.Size() < f.offset {
+		return false
+	}
+	id := fileID(st)
+	if id == "" {
+		return true
+	}
+	saved, err := f.getContent(f.idFilename())
+	return err != nil || saved == "" || saved == id
+}
+
+// commitOffset 需要持久化的偏移量, 不含合并中的内容
+func (f *FileInfo) commitOffset() int64 {
+	return atomic.LoadInt64(&f.offset) - atomic.LoadInt64(&f.mergeSize)
+}
+
 func (f *FileInfo) loadBeginOffset() int64 {
 	return atomic.LoadInt64(&f.beginOffset)
 }
@@ -320,6 +642,13 @@ func (f *FileInfo) initOffset() {
 	offsetInt, _ := strconv.Atoi(offset)
 	f.offset = int64(offsetInt)
 	f.beginOffset = f.offset
+	if f.offset == 0 {
+		return
+	}
+	if st, err := os.Stat(f.FileName()); err == nil && !f.sameFile(st) {
+		plg.Infof("saved offset of %q is not for current file, it will collect from begin", f.FileName())
+		f.offset, f.beginOffset = 0, 0
+	}
 }
 
 func (f *FileInfo) cleanOffset() (skip bool) {
@@ -340,7 +669,7 @@ func (f *FileInfo) saveOffset(mustSaveOffset bool) {
 	filename := f.offsetFilename()
 	// 判断下是否需要持久化
 	if mustSaveOffset || f.Handler.Change == -1 {
-		if _, err := f.putContent(filename, base.ToString(f.offset)); err != nil {
+		if _, err := f.putContent(filename, base.ToString(f.commitOffset())); err != nil {
 			plg.Error("f.putContent is failed, err:", err)
 		}
 		return
@@ -348,7 +677,7 @@ func (f *FileInfo) saveOffset(mustSaveOffset bool) {
 
 	f.offsetChange++
 	if f.offsetChange > f.Handler.Change {
-		if _, err := f.putContent(filename, base.ToString(f.offset)); err != nil {
+		if _, err := f.putContent(filename, base.ToString(f.commitOffset())); err != nil {
 			plg.Error("f.putContent is failed, err:", err)
 		}
 		f.removeOffsetFile()
@@ -389,7 +718,7 @@ func (f *FileInfo) remove(path string) {
 // removeOffsetFile 移除保存文件偏移量的文件
 func (f *FileInfo) removeOffsetFile(filename ...string) {
 	if len(filename) > 0 && filename[0] != "" {
-		if err := os.Remove(filename[0]); err != nil {
+		if err := os.Remove(filename[0]); err != nil && !os.IsNotExist(err) {
 			plg.Errorf("os.Remove %q is failed, err: %v", filename[0], err)
 		}
 		f.remove(filename[0])
@@ -412,6 +741,51 @@ func (f *FileInfo) removeOffsetFile(filename ...string) {
 	})
 }
 
+// walkDir 遍历目录下的文件, 递归时会按 Handler.MaxDepth 遍历子目录, 并跳过 saveOffsetDir
+func (f *FileInfo) walkDir(dir string, handle func(filename string, info os.FileInfo) error) error {
+	if !f.Handler.Recursive {
+		return f.loopDir(dir, func(info os.FileInfo) error {
+			return handle(filepath.Join(dir, info.Name()), info)
+		})
+	}
+
+	root := f.Handler.path
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return handle(path, info)
+		}
+		if path == root {
+			return nil
+		}
+		if info.Name() == saveOffsetDir || !dirInDepth(root, path, f.Handler.MaxDepth) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// dirInDepth 判断 dir 下的文件相对 root 的层级是否超过 maxDepth, 0 为不限制
+// 如: root/a/b.log 的层级为 2, 即 root/a 的层级为 1
+func dirInDepth(root, dir string, maxDepth int) bool {
+	if maxDepth <= 0 {
+		return true
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return false
+	}
+	if rel == "." {
+		return true
+	}
+	return len(strings.Split(rel, string(filepath.Separator))) < maxDepth
+}
+
 // loopDir 遍历目录, 只会遍历一级子级
 func (f *FileInfo) loopDir(path string, handle func(info os.FileInfo) error) error {
 	entrys, err := os.ReadDir(path)
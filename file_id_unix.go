@@ -0,0 +1,18 @@
+//go:build !windows
+
+package pslog
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileID 文件标识(设备号和 inode), 用于判断保存的偏移量是否属于当前的文件
+func fileID(st os.FileInfo) string {
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", sys.Dev, sys.Ino)
+}
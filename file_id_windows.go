@@ -0,0 +1,10 @@
+//go:build windows
+
+package pslog
+
+import "os"
+
+// fileID 文件标识, windows 下不支持, 按偏移量不超过文件大小判断
+func fileID(st os.FileInfo) string {
+	return ""
+}
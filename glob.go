@@ -0,0 +1,114 @@
+package pslog
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	globAnyDir = "**" // 匹配任意层级的目录
+)
+
+// Glob 路径匹配, 支持 filepath.Match 的语法, 以及 ** 匹配任意层级的目录
+// 如: /data/*/log/*.log, /data/**/app.log
+type Glob struct {
+	pattern  string
+	root     string   // pattern 中不含通配符的目录, 即需要监听的目录
+	segments []string // root 之后按分隔符拆分的 pattern
+}
+
+// NewGlob 解析 pattern
+func NewGlob(pattern string) (*Glob, error) {
+	pattern = filepath.Clean(pattern)
+	sep := string(filepath.Separator)
+	parts := strings.Split(pattern, sep)
+
+	// 找到第一个含通配符的部分, 之前的为 root
+	i := 0
+	for ; i < len(parts); i++ {
+		if hasMeta(parts[i]) {
+			break
+		}
+	}
+	if i == len(parts) {
+		return nil, fmt.Errorf("%q has no wildcard", pattern)
+	}
+	if i == len(parts)-1 && parts[i] == globAnyDir {
+		return nil, fmt.Errorf("%q can not end with %s", pattern, globAnyDir)
+	}
+
+	root := strings.Join(parts[:i], sep)
+	if root == "" {
+		root = "."
+		if filepath.IsAbs(pattern) {
+			root = sep
+		}
+	}
+	g := &Glob{pattern: pattern, root: root, segments: parts[i:]}
+	for _, seg := range g.segments {
+		if seg == globAnyDir {
+			continue
+		}
+		if _, err := filepath.Match(seg, ""); err != nil {
+			return nil, fmt.Errorf("%q is bad pattern, err: %v", pattern, err)
+		}
+	}
+	return g, nil
+}
+
+// Pattern 原始的 pattern
+func (g *Glob) Pattern() string {
+	return g.pattern
+}
+
+// Root 需要监听的目录
+func (g *Glob) Root() string {
+	return g.root
+}
+
+// MaxDepth 文件相对 Root 的最大层级, 含 ** 时为 0, 即不限制
+func (g *Glob) MaxDepth() int {
+	for _, seg := range g.segments {
+		if seg == globAnyDir {
+			return 0
+		}
+	}
+	return len(g.segments)
+}
+
+// Match 判断文件全路径是否匹配
+func (g *Glob) Match(filename string) bool {
+	rel, err := filepath.Rel(g.root, filepath.Clean(filename))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return matchSegments(g.segments, strings.Split(rel, string(filepath.Separator)))
+}
+
+// matchSegments 逐级匹配, ** 可以匹配 0 个或多个目录
+func matchSegments(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == globAnyDir {
+			for i := 0; i <= len(names); i++ {
+				if matchSegments(patterns[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(patterns[0], names[0]); !ok {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// hasMeta 是否含有通配符
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
@@ -0,0 +1,162 @@
+package pslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitee.com/xuesongtao/gotool/xfile"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		filename string
+		root     string
+		maxDepth int
+		match    bool
+	}{
+		{"/data/*/log/*.log", "/data/demo/log/app.log", "/data", 3, true},
+		{"/data/*/log/*.log", "/data/demo/log/app.txt", "/data", 3, false},
+		{"/data/*/log/*.log", "/data/demo/sub/log/app.log", "/data", 3, false},
+		{"/data/**/app.log", "/data/app.log", "/data", 0, true},
+		{"/data/**/app.log", "/data/2026-10/17/app.log", "/data", 0, true},
+		{"/data/**/log/*.log", "/data/a/b/log/app.log", "/data", 0, true},
+		{"/data/**/log/*.log", "/data/a/b/app.log", "/data", 0, false},
+		{"/data/*/log/*.log", "/other/demo/log/app.log", "/data", 3, false},
+		{"log/app-*.log", "log/app-2026-10-17.log", "log", 1, true},
+	}
+	for _, tt := range tests {
+		g, err := NewGlob(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Root() != tt.root || g.MaxDepth() != tt.maxDepth {
+			t.Errorf("%q root: %q, maxDepth: %d", tt.pattern, g.Root(), g.MaxDepth())
+		}
+		if got := g.Match(tt.filename); got != tt.match {
+			t.Errorf("%q match %q got: %v", tt.pattern, tt.filename, got)
+		}
+	}
+
+	for _, pattern := range []string{"/data/log/app.log", "/data/**", "/data/[/*.log"} {
+		if _, err := NewGlob(pattern); err == nil {
+			t.Errorf("%q should be invalid", pattern)
+		}
+	}
+}
+
+func TestTailGlob(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	dir := t.TempDir()
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+	}
+	if err := ps.AddGlob(filepath.Join(dir, "*", "log", "*.log"), handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不匹配的先写入, 采集到匹配的时不匹配的事件已处理
+	for _, filename := range []string{"demo2/log/app.txt", "demo3/app.log", "demo1/log/app.log", "demo2/log/app.log"} {
+		filename = filepath.Join(dir, filename)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := xfile.AppendContent(filename, "warning "+filename+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool { return strings.Count(strBuf.String(), "warning") == 2 })
+
+	if got := strBuf.String(); strings.Count(got, "warning") != 2 || !strings.Contains(got, "demo1") || !strings.Contains(got, "demo2/log/app.log") {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestGlobSameRoot(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	dir := t.TempDir()
+	handler := &Handler{
+		Change:   -1,
+		Tail:     true,
+		ExpireAt: NoExpire,
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+	}
+	pattern := filepath.Join(dir, "*", "*.log")
+	if err := ps.AddGlob(pattern, handler); err != nil {
+		t.Fatal(err)
+	}
+	// 同一个 pattern 跳过或替换
+	if err := ps.AddGlob(pattern, handler); err != nil {
+		t.Error(err)
+	}
+	if err := ps.ReplaceGlob(pattern, handler); err != nil {
+		t.Error(err)
+	}
+
+	// 不含通配符的目录相同的其他 pattern 或目录, 不能覆盖已有的
+	other := filepath.Join(dir, "*", "*.txt")
+	if err := ps.AddGlob(other, handler); err == nil {
+		t.Error("AddGlob should be failed")
+	}
+	if err := ps.ReplaceGlob(other, handler); err == nil {
+		t.Error("ReplaceGlob should be failed")
+	}
+	if err := ps.AddDir2Handle(dir, handler); err == nil {
+		t.Error("AddDir2Handle should be failed")
+	}
+	if got := ps.logMap[dir].Handler.globPattern(); got != pattern {
+		t.Errorf("got: %q", got)
+	}
+
+	for _, filename := range []string{"demo/app.log", "demo/app.txt"} {
+		filename = filepath.Join(dir, filename)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := xfile.AppendContent(filename, "warning "+filename+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool { return strings.Contains(strBuf.String(), "app.log") })
+	if got := strBuf.String(); strings.Contains(got, "app.txt") {
+		t.Errorf("got: %q", got)
+	}
+
+	if err := ps.RemovePath(pattern); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ps.logMap[dir]; ok {
+		t.Error("glob is not removed")
+	}
+	if err := ps.AddGlob(other, handler); err != nil {
+		t.Error(err)
+	}
+}
@@ -7,4 +7,5 @@ require (
 	gitee.com/xuesongtao/taskpool v1.2.13
 	github.com/fsnotify/fsnotify v1.7.0
 	github.com/olekukonko/tablewriter v0.0.5
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -5,11 +5,22 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"gitee.com/xuesongtao/ps-log/line"
+	plg "gitee.com/xuesongtao/ps-log/log"
 )
 
+// LogHandlerBus.Labels 中内置的标签
+const (
+	LabelProject   = "project"   // 项目名, 通过 LogPath 解析的 path 才有
+	LabelNamespace = "namespace" // 命名空间, 通过 LogPath 解析的 path 或 pod 的日志才有
+	LabelHostname  = "hostname"  // 主机名, 默认都有
+)
+
+// PsLogWriter 处理匹配到的内容
+// 说明: 不同文件的解析是并行的, 同一个 writer 会被并发调用 WriteTo, 实现需要并发安全
 type PsLogWriter interface {
 	WriteTo(bus *LogHandlerBus)
 }
@@ -20,6 +31,34 @@ func (p *Stdout) WriteTo(bus *LogHandlerBus) {
 	os.Stdout.WriteString(bus.Msg)
 }
 
+// File 追加写入文件
+type File struct {
+	mu sync.Mutex
+	fh *os.File
+}
+
+func NewFile(filename string) (*File, error) {
+	fh, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("os.OpenFile %q is failed, err: %v", filename, err)
+	}
+	return &File{fh: fh}, nil
+}
+
+func (f *File) WriteTo(bus *LogHandlerBus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.fh.WriteString(bus.Msg); err != nil {
+		plg.Errorf("write %q is failed, err: %v", f.fh.Name(), err)
+	}
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fh.Close()
+}
+
 // Target 目标内容
 type Target struct {
 	no       int    // 自增编号
@@ -32,42 +71,215 @@ type Target struct {
 
 // Handler 处理的部分
 type Handler struct {
-	LoopParse   bool          // 循环解析, 用于监听单文件日志, 说明: 这个采集的有可能不准确(在这种是基于文件大小和内存记录的偏移量做比较, 模式建议用 tail, cron 的话如果间隔时间太长就可能漏)
-	CleanOffset bool          // 是否需要清理保存的 offset, 只限于开机后一次
-	Tail        bool          // 是否实时处理, 说明: true 为实时; false 需要外部定时调用
-	Change      int32         // 文件 offset 变化次数, 为持久化文件偏移量数阈值, 当, 说明: -1 为实时保存; 0 达到默认值 defaultHandleChange 时保存; 其他 大于后会保存
-	ExpireDur   time.Duration // 文件句柄过期间隔, 常用于全局配置, 如果没有, 默认 1 小时
-	ExpireAt    time.Time     // 文件句柄过期时间, 优先 ExpireDur 如: 2022-12-03 11:11:10
-	MergeRule   line.Merger   // 日志文件行合并规则, 默认 单行处理
-	targets     Matcher
-	Targets     []*Target                  // 目标 msg
-	Ext         string                     // 外部存入, 回调返回
-	NeedCollect func(filename string) bool // 当监听的对象为目录时, 判断文件是否需要采集, 注: 采集的 path 为 dir 的时候, 这里必须填
-
-	isDir bool
-	path  string // 原始 path
-	initd bool   // 是否已经初始化
+	LoopParse    bool          // 循环解析, 用于监听单文件日志, 说明: 这个采集的有可能不准确(在这种是基于文件大小和内存记录的偏移量做比较, 模式建议用 tail, cron 的话如果间隔时间太长就可能漏)
+	CleanOffset  bool          // 是否需要清理保存的 offset, 只限于开机后一次
+	Tail         bool          // 是否实时处理, 说明: true 为实时; false 需要外部定时调用
+	Poll         bool          // 实时处理时是否用轮询代替 inotify, 用于不支持 inotify 的文件系统(如: NFS), 轮询间隔见 WithPollInterval
+	Change       int32         // 文件 offset 变化次数, 为持久化文件偏移量数阈值, 当, 说明: -1 为实时保存; 0 达到默认值 defaultHandleChange 时保存; 其他 大于后会保存
+	ExpireDur    time.Duration // 文件句柄过期间隔, 常用于全局配置, 如果没有, 默认 1 小时
+	ExpireAt     time.Time     // 文件句柄过期时间, 优先 ExpireDur 如: 2022-12-03 11:11:10
+	IdleExpire   bool          // 是否按空闲过期, 说明: true 时每次解析都会延期 ExpireDur, 空闲过期后只关闭句柄不移除, 再次写入时会重新打开; ExpireAt 不生效
+	MergeRule    line.Merger   // 日志文件行合并规则, 默认 单行处理, 注: copy 时会 Clone, 每个文件独立合并
+	Decoder      line.Decoder  // 行解码, 在 MergeRule 之前对原始行进行解码, 如: line.NewDocker(), line.NewCRI(), 默认 不解码, 注: copy 时会 Clone
+	MergeTimeout time.Duration // 合并中的内容多久没有新的行时输出, 如: 文件最后一个 err stack, 默认 2s; -1 为不超时, 只在下一个起始行出现时输出
+	targets      Matcher
+	Targets      []*Target                  // 目标 msg
+	Ext          string                     // 外部存入, 回调返回
+	Labels       map[string]string          // 标签, 会带到 LogHandlerBus.Labels 中, 便于按服务路由, 过滤, 默认会带上 LabelHostname
+	NeedCollect  func(filename string) bool // 当监听的对象为目录时, 判断文件是否需要采集, 注: 采集的 path 为 dir 的时候, 这里必须填
+	Recursive    bool                       // 当监听的对象为目录时, 是否递归采集子目录, 新建的子目录也会被监听, NeedCollect 的入参为文件全路径
+	MaxDepth     int                        // 递归时文件相对目录的最大层级, 如: dir/2026-10/17/app.log 为 3, 0 为不限制
+
+	// 解码, 合并中的状态, 每个文件独立
+	fields       map[string]string // 合并中的事件第一条记录的字段
+	recordOffset int64             // 解码中的记录开始的偏移量
+	mergeOffset  int64             // 合并中的事件开始的偏移量
+	records      []recordPos       // 合并中的记录, 用于按 MergeRule.Pending 计算合并中的事件开始的位置
+
+	isDir      bool
+	attached   []*Handler                              // 同一 path 上附加的 handler, 共用文件的读取和偏移量, Targets, MergeRule 等各自独立
+	pathLabels func(filename string) map[string]string // 根据目录下文件的路径解析标签, 如: pod 的 namespace
+	glob       *Glob                                   // 通过 AddGlob 添加时的 pattern
+	pending    bool                                    // path 是否还不存在, 等出现后再开始采集
+	path       string                                  // 原始 path
+	initd      bool                                    // 是否已经初始化
 }
 
 func (h *Handler) copy() *Handler {
+	// 合并, 解码中的内容按文件独立
+	var mergeRule line.Merger
+	if h.MergeRule != nil {
+		mergeRule = h.MergeRule.Clone()
+	}
+	var decoder line.Decoder
+	if h.Decoder != nil {
+		decoder = h.Decoder.Clone()
+	}
 	return &Handler{
-		LoopParse:   h.LoopParse,
-		CleanOffset: h.CleanOffset,
-		Tail:        h.Tail,
-		Change:      h.Change,
-		ExpireDur:   h.ExpireDur,
-		ExpireAt:    h.ExpireAt,
-		MergeRule:   h.MergeRule,
+		LoopParse:    h.LoopParse,
+		CleanOffset:  h.CleanOffset,
+		Tail:         h.Tail,
+		Poll:         h.Poll,
+		Change:       h.Change,
+		ExpireDur:    h.ExpireDur,
+		ExpireAt:     h.ExpireAt,
+		IdleExpire:   h.IdleExpire,
+		MergeRule:    mergeRule,
+		Decoder:      decoder,
+		MergeTimeout: h.MergeTimeout,
 		// targets:     nil,
-		Targets:     h.Targets,
+		Targets:     h.copyTargets(),
 		Ext:         h.Ext,
+		Labels:      h.Labels,
 		NeedCollect: h.NeedCollect,
+		Recursive:   h.Recursive,
+		MaxDepth:    h.MaxDepth,
+		attached:    h.copyAttached(),
+		pathLabels:  h.pathLabels,
 		// isDir:       false,
 		// path:        "",
 		// initd:       false,
 	}
 }
 
+// copyTargets 复制 Targets, init 时会修改 no, excludes, 每个文件独立, 防止并发解析时读写冲突
+// 说明: To 中的 writer 共用
+func (h *Handler) copyTargets() []*Target {
+	if h.Targets == nil {
+		return nil
+	}
+	res := make([]*Target, len(h.Targets))
+	for i, v := range h.Targets {
+		if v == nil {
+			continue
+		}
+		tmp := *v
+		res[i] = &tmp
+	}
+	return res
+}
+
+func (h *Handler) copyAttached() []*Handler {
+	if len(h.attached) == 0 {
+		return nil
+	}
+	res := make([]*Handler, len(h.attached))
+	for i, v := range h.attached {
+		res[i] = v.copy()
+	}
+	return res
+}
+
+// copyFor 为目录下的文件复制 handler, 只保留需要采集该文件的 handler
+// 说明: 第一个需要采集的 handler 作为主 handler, 其余的为附加的
+func (h *Handler) copyFor(filename string) *Handler {
+	var res *Handler
+	for _, v := range h.handlers() {
+		if v.NeedCollect != nil && !v.NeedCollect(filename) {
+			continue
+		}
+		tmp := v.copy()
+		tmp.attached = nil
+		if tmp.pathLabels != nil {
+			tmp.Labels = mergeLabels(tmp.Labels, tmp.pathLabels(filename))
+		}
+		if res == nil {
+			res = tmp
+			continue
+		}
+		res.attached = append(res.attached, tmp)
+	}
+	if res == nil {
+		return h.copy()
+	}
+	return res
+}
+
+// mergeLabels 合并标签, 后面的优先
+func mergeLabels(labels ...map[string]string) map[string]string {
+	res := make(map[string]string)
+	for _, v := range labels {
+		for key, value := range v {
+			res[key] = value
+		}
+	}
+	return res
+}
+
+// handlers 当前 handler 及附加的 handler
+func (h *Handler) handlers() []*Handler {
+	res := make([]*Handler, 0, 1+len(h.attached))
+	res = append(res, h)
+	return append(res, h.attached...)
+}
+
+// globPattern 通过 AddGlob 添加时的 pattern, 不是时为空
+func (h *Handler) globPattern() string {
+	if h.glob == nil {
+		return ""
+	}
+	return h.glob.Pattern()
+}
+
+// needCollect 判断文件是否需要采集, 有一个 handler 需要即可
+func (h *Handler) needCollect(filename string) bool {
+	for _, v := range h.handlers() {
+		if v.NeedCollect != nil && v.NeedCollect(filename) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordPos 合并中的记录开始的偏移量, 合并的长度和字段
+type recordPos struct {
+	offset int64
+	size   int
+	fields map[string]string
+}
+
+// syncMergeOffset 合并完成后还有合并中的内容时(如: JSON 格式不对时之后的行重新合并), 按剩余的长度找到开始的记录,
+// 作为合并中的事件开始的偏移量和字段
+// 说明: 长度对不上时以最早的记录为准, 重启后多读取不会丢失
+func (h *Handler) syncMergeOffset() {
+	pending := h.MergeRule.Pending()
+	if pending == 0 || len(h.records) == 0 {
+		h.records = h.records[:0]
+		return
+	}
+	i, size := len(h.records)-1, 0
+	for ; i > 0; i-- {
+		if size += h.records[i].size; size >= pending {
+			break
+		}
+	}
+	h.mergeOffset = h.records[i].offset
+	h.fields = h.records[i].fields
+	h.records = append(h.records[:0], h.records[i:]...)
+}
+
+// resetState 丢弃解码, 合并中的内容
+func (h *Handler) resetState() {
+	if h.MergeRule != nil {
+		h.MergeRule = h.MergeRule.Clone()
+	}
+	if h.Decoder != nil {
+		h.Decoder = h.Decoder.Clone()
+	}
+	h.fields = nil
+	h.records = nil
+}
+
+// hasPending 是否有解码, 合并中的内容
+func (h *Handler) hasPending() bool {
+	return h.decoding() || h.MergeRule.Pending() > 0
+}
+
+// decoding 是否有解码中的内容
+func (h *Handler) decoding() bool {
+	return h.Decoder != nil && h.Decoder.Pending() > 0
+}
+
 // initMatcher 初始化匹配
 // arrLen 为匹配的数组长度
 func (h *Handler) initMatcher(arrLen int) Matcher {
@@ -86,6 +298,10 @@ func (h *Handler) Valid() error {
 		return errors.New("Targets is required")
 	}
 
+	if h.MaxDepth < 0 {
+		return errors.New("MaxDepth can not less than 0")
+	}
+
 	for i, target := range h.Targets {
 		if target.Content == "" {
 			return fmt.Errorf("Targets.Content[%d] is null", i)
@@ -106,6 +322,14 @@ func (h *Handler) init() error {
 		return err
 	}
 
+	// 附加的 handler 与当前 handler 采集同一 path
+	for _, v := range h.attached {
+		v.path = h.path
+		if err := v.init(); err != nil {
+			return err
+		}
+	}
+
 	h.initd = true
 	if h.Change == 0 {
 		h.Change = defaultHandleChange
@@ -115,6 +339,10 @@ func (h *Handler) init() error {
 		h.ExpireDur = time.Hour
 	}
 
+	if h.MergeTimeout == 0 {
+		h.MergeTimeout = defaultMergeTimeout
+	}
+
 	if h.ExpireAt.IsZero() {
 		h.ExpireAt = time.Now().Add(h.ExpireDur)
 	}
@@ -123,6 +351,9 @@ func (h *Handler) init() error {
 		h.MergeRule = line.NewSing()
 	}
 
+	// 默认带上主机名, 可以被 Labels 覆盖
+	h.Labels = mergeLabels(map[string]string{LabelHostname: hostname}, h.Labels)
+
 	// 预处理 targets, exclude
 	h.targets = h.initMatcher(len(h.Targets))
 	no := 1
@@ -145,7 +376,14 @@ func (h *Handler) init() error {
 	// 判断下是否为目录
 	st, err := os.Stat(h.path)
 	if err != nil {
-		return fmt.Errorf("os.Stat %q is failed, err: %v", h.path, err)
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("os.Stat %q is failed, err: %v", h.path, err)
+		}
+		// path 还不存在, 先登记, 出现后再开始采集; 有 NeedCollect 的按目录处理
+		plg.Warningf("%q is not exist, it will collect when created", h.path)
+		h.pending = true
+		h.isDir = h.NeedCollect != nil
+		return nil
 	}
 	h.isDir = st.IsDir()
 
@@ -157,7 +395,7 @@ func (h *Handler) init() error {
 
 func (h *Handler) getTargetDump() string {
 	data := ""
-	for _, v := range h.Targets {
+	for _, v := range h.allTargets() {
 		data += "【" + v.Content + "】"
 	}
 	return data
@@ -165,7 +403,7 @@ func (h *Handler) getTargetDump() string {
 
 func (h *Handler) getExcludesDump() string {
 	data := ""
-	for _, t := range h.Targets {
+	for _, t := range h.allTargets() {
 		es := ""
 		for _, e := range t.Excludes {
 			if es == "" {
@@ -182,12 +420,23 @@ func (h *Handler) getExcludesDump() string {
 	return data
 }
 
+// allTargets 当前及附加的 handler 的 Targets
+func (h *Handler) allTargets() []*Target {
+	res := make([]*Target, 0, len(h.Targets))
+	for _, handler := range h.handlers() {
+		res = append(res, handler.Targets...)
+	}
+	return res
+}
+
 // logHandler 解析到的内容
 type LogHandlerBus struct {
-	LogPath   string // log 的路径
-	Msg       string // buf 中的 string
-	Ext       string // Handler 中的 Ext 值
-	TargetExt string // Target 中的 Ext 值
+	LogPath   string            // log 的路径
+	Msg       string            // buf 中的 string
+	Ext       string            // Handler 中的 Ext 值
+	TargetExt string            // Target 中的 Ext 值
+	Fields    map[string]string // Handler.Decoder 解码出的字段, 如: stream, time, 有值时每条记录单独输出
+	Labels    map[string]string // Handler 中的 Labels 值, 如: project, namespace, hostname, 注: 只读, 同一 handler 的 bus 共用
 
 	buf *bytes.Buffer
 	tos []PsLogWriter
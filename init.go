@@ -1,13 +1,17 @@
 package pslog
 
 import (
+	"os"
+	"time"
+
 	"gitee.com/xuesongtao/gotool/base"
 	"gitee.com/xuesongtao/gotool/xfile"
 	plg "gitee.com/xuesongtao/ps-log/log"
 )
 
 const (
-	defaultHandleChange = 100 // 默认记录 offset 变化的次数
+	defaultHandleChange = 100             // 默认记录 offset 变化的次数
+	defaultMergeTimeout = 2 * time.Second // 默认合并中的内容多久没有新的行时输出
 
 	// 控制台 logo
 	consoleLogo string = `   
@@ -23,8 +27,9 @@ ______    ______         |  |    ____     ____
 )
 
 var (
-	filePool = xfile.NewFilePool(10)                        // 文件池
-	NoExpire = base.Datetime2TimeObj("9999-12-31 23:59:59") // 不过期
+	filePool    = xfile.NewFilePool(10)                        // 文件池
+	NoExpire    = base.Datetime2TimeObj("9999-12-31 23:59:59") // 不过期
+	hostname, _ = os.Hostname()                                // 主机名, 会带到 LogHandlerBus.Labels 中
 )
 
 // SetLogger 设置 logger
@@ -0,0 +1,83 @@
+package pslog
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultPodLogDir = "/var/log/pods" // kubernetes 节点上 pod 日志的目录
+)
+
+// PodLog pod 日志的信息
+type PodLog struct {
+	Namespace string
+	Pod       string
+	UID       string
+	Container string
+	LogPath   string
+}
+
+// ParsePodLog 根据日志路径解析 pod 信息
+// 路径格式: <root>/<namespace>_<pod>_<uid>/<container>/<n>.log, 如: /var/log/pods/default_nginx-7c5b_0d8e/nginx/0.log
+func ParsePodLog(root, filename string) (*PodLog, bool) {
+	rel, err := filepath.Rel(root, filename)
+	if err != nil {
+		return nil, false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) != 3 {
+		return nil, false
+	}
+	// namespace 和 pod 名不会含有 _
+	pod := strings.SplitN(parts[0], "_", 3)
+	if len(pod) != 3 {
+		return nil, false
+	}
+	return &PodLog{
+		Namespace: pod[0],
+		Pod:       pod[1],
+		UID:       pod[2],
+		Container: parts[1],
+		LogPath:   filename,
+	}, true
+}
+
+// Labels pod 信息的标签
+func (p *PodLog) Labels() map[string]string {
+	return map[string]string{
+		LabelNamespace: p.Namespace,
+		"pod":          p.Pod,
+		"pod_uid":      p.UID,
+		"container":    p.Container,
+	}
+}
+
+// AddPods 采集 kubernetes 节点上 pod 的日志, root 默认为 /var/log/pods
+// 说明:
+//  1. 会监听 root, 新建的 pod 会自动采集, 删除的 pod 会自动移除
+//  2. 每个 LogHandlerBus.Labels 中会带上 namespace, pod, pod_uid, container
+//  3. 日志为 CRI 格式, 建议 handler.Decoder 设置为 line.NewCRI()
+//  4. handler 为 nil 时, 会按 p.handler 来处理, 注: 使用的是 handler 的副本
+func (p *PsLog) AddPods(handler *Handler, root ...string) error {
+	defaultRoot := defaultPodLogDir
+	if len(root) > 0 && root[0] != "" {
+		defaultRoot = filepath.Clean(root[0])
+	}
+	if handler == nil {
+		handler = p.handler
+	}
+	if handler == nil {
+		return fmt.Errorf("%q no has handler", defaultRoot)
+	}
+	handler = handler.copy()
+	handler.pathLabels = func(filename string) map[string]string {
+		pod, ok := ParsePodLog(defaultRoot, filename)
+		if !ok {
+			return nil
+		}
+		return pod.Labels()
+	}
+	return p.AddGlob(filepath.Join(defaultRoot, "*_*_*", "*", "*.log"), handler)
+}
@@ -0,0 +1,82 @@
+package pslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitee.com/xuesongtao/gotool/xfile"
+	"gitee.com/xuesongtao/ps-log/line"
+)
+
+func TestParsePodLog(t *testing.T) {
+	root := "/var/log/pods"
+	pod, ok := ParsePodLog(root, "/var/log/pods/default_nginx-7c5b_0d8e-11/nginx/0.log")
+	if !ok {
+		t.Fatal("parse is failed")
+	}
+	if pod.Namespace != "default" || pod.Pod != "nginx-7c5b" || pod.UID != "0d8e-11" || pod.Container != "nginx" {
+		t.Errorf("pod: %+v", pod)
+	}
+	if _, ok := ParsePodLog(root, "/var/log/pods/nginx/0.log"); ok {
+		t.Error("it should is failed")
+	}
+}
+
+func TestTailPods(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	fieldsBuf := new(FieldsBuf)
+	root := t.TempDir()
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Decoder:  line.NewCRI(),
+		Labels:   map[string]string{"cluster": "test"},
+		Targets: []*Target{
+			{
+				Content: "ERRO",
+				To:      []PsLogWriter{fieldsBuf},
+			},
+		},
+	}
+	if err := ps.AddPods(handler, root); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新建的 pod
+	podLog := filepath.Join(root, "default_nginx-7c5b_0d8e", "nginx", "0.log")
+	if err := os.MkdirAll(filepath.Dir(podLog), 0755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return watching(ps.watch, filepath.Dir(podLog)) })
+	if _, err := xfile.AppendContent(podLog, "2026-10-19T10:00:00.000000001Z stderr F [ERRO] boom\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, podLog) })
+
+	buses := fieldsBuf.Get()
+	if len(buses) != 1 {
+		t.Fatalf("buses: %+v", buses)
+	}
+	labels := buses[0].Labels
+	if labels["namespace"] != "default" || labels["pod"] != "nginx-7c5b" || labels["container"] != "nginx" || labels["cluster"] != "test" {
+		t.Errorf("labels: %v", labels)
+	}
+	if !strings.HasPrefix(buses[0].Msg, "[ERRO] boom") || buses[0].Fields["stream"] != "stderr" {
+		t.Errorf("bus: %+v", buses[0])
+	}
+
+	// 删除的 pod
+	if err := os.RemoveAll(filepath.Join(root, "default_nginx-7c5b_0d8e")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return len(ps.logMap[root].childList()) == 0 })
+}
@@ -1,7 +1,20 @@
 package line
 
 type Merger interface {
-	Null() bool              // 执行结束后, 需要判断是否为空, 如果不为空, 就再调用 Line
+	Null() bool              // 需要输出合并中的内容时, 判断是否为空, 如果不为空, 就再调用 Line
 	Line() []byte            // 获取 merge 成功 line, 注: 获取完后, 应该调用一次 Residue 获取剩余的内容
 	Append(data []byte) bool // 追加行内容, 如果返回 true 表示满足 merge 成功, 应该调用 Line 获取行内容; 反之未完成
+	Pending() int            // 合并中还未完成的内容长度, 这部分会保留到下次解析
+	Clone() Merger           // 复制一个规则相同的 Merger, 不含合并中的内容, 用于每个文件独立合并
+}
+
+// Multiple 一次 Append 或 Null 可能完成多行时实现, 如: JSON 格式不对时逐行输出
+type Multiple interface {
+	More() bool // 调用 Line 之后是否还有已完成的行, 有时再调用 Line 获取
+}
+
+// More 调用 Line 之后是否还有已完成的行
+func More(m Merger) bool {
+	multi, ok := m.(Multiple)
+	return ok && multi.More()
 }
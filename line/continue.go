@@ -0,0 +1,30 @@
+package line
+
+// Continue 多行处理, 匹配续行的正则表达式的行追加到上一行, 如: java 堆栈中以空白, at, Caused by: 开头的行
+type Continue struct {
+	merge
+}
+
+func NewContinue() *Continue {
+	return &Continue{}
+}
+
+// ContinuePattern 续行的正则表达式, 如: `^(\s+|at |Caused by:)`
+func (c *Continue) ContinuePattern(expr string) error {
+	return c.compile(expr)
+}
+
+func (c *Continue) Append(data []byte) bool {
+	if len(data) == 0 { // 文件读取结束时可能为空
+		return false
+	}
+	if !c.match(data) || c.full() {
+		c.flush()
+	}
+	c.write(data)
+	return len(c.line) > 0
+}
+
+func (c *Continue) Clone() Merger {
+	return &Continue{merge: c.clone()}
+}
@@ -0,0 +1,92 @@
+package line
+
+import (
+	"bytes"
+)
+
+const (
+	criPartial = "P" // 被拆分的行
+	criFull    = "F" // 完整的行或被拆分的最后一部分
+)
+
+// CRI containerd, CRI-O 的日志格式, 如: 2026-10-19T10:00:00.000000000Z stderr F msg
+// 说明:
+//  1. 解码后的行为去掉前缀的内容, time, stream 放在 Record.Fields 中
+//  2. 标记为 P 的行为被拆分的行, 会和之后的行拼接, 直到标记为 F 的行, Fields 以第一条为准
+//  3. 格式不对的行原样输出
+type CRI struct {
+	raw     rawLine
+	buf     bytes.Buffer // 被拆分的行
+	fields  map[string]string
+	pending int // buf 对应的原始内容长度
+}
+
+func NewCRI() *CRI {
+	return &CRI{}
+}
+
+func (c *CRI) Decode(row []byte) (*Record, bool) {
+	row, ok := c.raw.complete(row)
+	if !ok {
+		return nil, false
+	}
+	return c.decode(row)
+}
+
+func (c *CRI) Flush() (*Record, bool) {
+	if row, ok := c.raw.flush(); ok {
+		if record, ok := c.decode(row); ok {
+			return record, true
+		}
+	}
+	if c.buf.Len() == 0 {
+		return nil, false
+	}
+	return c.record(), true
+}
+
+func (c *CRI) Pending() int {
+	return c.raw.buf.Len() + c.pending
+}
+
+func (c *CRI) Clone() Decoder {
+	return NewCRI()
+}
+
+func (c *CRI) decode(row []byte) (*Record, bool) {
+	// 格式: <time> <stream> <tag> <content>
+	parts := bytes.SplitN(row, []byte(" "), 4)
+	if len(parts) < 4 {
+		// 内容为空时没有最后一个空格, 如: <time> <stream> F\n
+		parts = bytes.SplitN(bytes.TrimRight(row, "\r\n"), []byte(" "), 3)
+		if len(parts) != 3 {
+			return &Record{Line: row}, true
+		}
+		parts = append(parts, []byte("\n"))
+	}
+	tag := string(parts[2])
+	if tag != criPartial && tag != criFull {
+		return &Record{Line: row}, true
+	}
+
+	if c.buf.Len() == 0 {
+		c.fields = map[string]string{"time": string(parts[0]), "stream": string(parts[1])}
+	}
+	content := parts[3]
+	c.pending += len(row)
+	if tag == criPartial {
+		c.buf.Write(bytes.TrimRight(content, "\r\n"))
+		return nil, false
+	}
+	c.buf.Write(content)
+	return c.record(), true
+}
+
+func (c *CRI) record() *Record {
+	record := &Record{Line: make([]byte, c.buf.Len()), Fields: c.fields}
+	copy(record.Line, c.buf.Bytes())
+	c.buf.Reset()
+	c.fields = nil
+	c.pending = 0
+	return record
+}
@@ -0,0 +1,51 @@
+package line
+
+import (
+	"bytes"
+)
+
+// Decoder 行解码, 在 Merger 之前对原始行进行解码, 如: docker json-file
+type Decoder interface {
+	Decode(row []byte) (*Record, bool) // 解码原始行, 返回 false 表示行还不完整(如: 被拆分的长行), 需要继续追加
+	Flush() (*Record, bool)            // 输出解码中还不完整的内容
+	Pending() int                      // 解码中还不完整的原始内容长度, 这部分会保留到下次解析
+	Clone() Decoder                    // 复制一个新的 Decoder, 不含解码中的内容, 用于每个文件独立解码
+}
+
+// Record 解码后的记录
+type Record struct {
+	Line   []byte            // 解码后的行内容
+	Fields map[string]string // 其他字段, 如: stream, time
+}
+
+// rawLine 还没写完的原始行, 文件读取到末尾时行可能还没有换行符
+type rawLine struct {
+	buf bytes.Buffer
+}
+
+// complete 返回完整的行, 返回 false 表示行还没写完
+func (r *rawLine) complete(row []byte) ([]byte, bool) {
+	if !bytes.HasSuffix(row, []byte("\n")) {
+		r.buf.Write(row)
+		return nil, false
+	}
+	if r.buf.Len() == 0 {
+		return row, true
+	}
+	r.buf.Write(row)
+	tmp := make([]byte, r.buf.Len())
+	copy(tmp, r.buf.Bytes())
+	r.buf.Reset()
+	return tmp, true
+}
+
+// flush 输出还没写完的行, 补上换行符
+func (r *rawLine) flush() ([]byte, bool) {
+	if r.buf.Len() == 0 {
+		return nil, false
+	}
+	tmp := make([]byte, r.buf.Len(), r.buf.Len()+1)
+	copy(tmp, r.buf.Bytes())
+	r.buf.Reset()
+	return append(tmp, '\n'), true
+}
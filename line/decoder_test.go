@@ -0,0 +1,94 @@
+package line
+
+import (
+	"testing"
+)
+
+func TestDocker(t *testing.T) {
+	d := NewDocker()
+	rows := []string{
+		`{"log":"hello\n","stream":"stdout","time":"2026-10-19T10:00:00.000000001Z"}` + "\n",
+		`{"log":"long ","stream":"stderr","time":"2026-10-19T10:00:00.000000002Z"}` + "\n",
+		`{"log":"line\n","stream":"stderr","time":"2026-10-19T10:00:00.000000003Z"}` + "\n",
+		`{"log":"partial\n","stream":"std`,
+		`out","time":"2026-10-19T10:00:00.000000004Z"}` + "\n",
+		"not json\n",
+	}
+	var records []*Record
+	for _, row := range rows {
+		if record, ok := d.Decode([]byte(row)); ok {
+			records = append(records, record)
+		}
+	}
+	if len(records) != 4 {
+		t.Fatalf("records: %d", len(records))
+	}
+	if string(records[0].Line) != "hello\n" || records[0].Fields["stream"] != "stdout" {
+		t.Errorf("record[0]: %q, %v", records[0].Line, records[0].Fields)
+	}
+	// 被拆分的行重新拼接, 字段以第一条为准
+	if string(records[1].Line) != "long line\n" || records[1].Fields["time"] != "2026-10-19T10:00:00.000000002Z" {
+		t.Errorf("record[1]: %q, %v", records[1].Line, records[1].Fields)
+	}
+	// 还没写完的行
+	if string(records[2].Line) != "partial\n" || records[2].Fields["stream"] != "stdout" {
+		t.Errorf("record[2]: %q, %v", records[2].Line, records[2].Fields)
+	}
+	if string(records[3].Line) != "not json\n" || records[3].Fields != nil {
+		t.Errorf("record[3]: %q, %v", records[3].Line, records[3].Fields)
+	}
+
+	// 解码中的内容
+	row := `{"log":"split ","stream":"stdout","time":"t"}` + "\n"
+	if _, ok := d.Decode([]byte(row)); ok {
+		t.Fatal("it should is pending")
+	}
+	if d.Pending() != len(row) {
+		t.Errorf("pending: %d", d.Pending())
+	}
+	if record, ok := d.Flush(); !ok || string(record.Line) != "split " {
+		t.Errorf("flush: %v", record)
+	}
+}
+
+func TestCRI(t *testing.T) {
+	c := NewCRI()
+	rows := []string{
+		"2026-10-19T10:00:00.000000001Z stdout F hello world\n",
+		"2026-10-19T10:00:00.000000002Z stderr P long \n",
+		"2026-10-19T10:00:00.000000003Z stderr P line \n",
+		"2026-10-19T10:00:00.000000004Z stderr F end\n",
+		"2026-10-19T10:00:00.000000005Z stdout F\n",
+		"not cri\n",
+	}
+	var records []*Record
+	for _, row := range rows {
+		if record, ok := c.Decode([]byte(row)); ok {
+			records = append(records, record)
+		}
+	}
+	if len(records) != 4 {
+		t.Fatalf("records: %d", len(records))
+	}
+	if string(records[0].Line) != "hello world\n" || records[0].Fields["stream"] != "stdout" {
+		t.Errorf("record[0]: %q, %v", records[0].Line, records[0].Fields)
+	}
+	// 被拆分的行重新拼接, 字段以第一条为准
+	if string(records[1].Line) != "long line end\n" || records[1].Fields["time"] != "2026-10-19T10:00:00.000000002Z" {
+		t.Errorf("record[1]: %q, %v", records[1].Line, records[1].Fields)
+	}
+	if string(records[2].Line) != "\n" {
+		t.Errorf("record[2]: %q", records[2].Line)
+	}
+	if string(records[3].Line) != "not cri\n" || records[3].Fields != nil {
+		t.Errorf("record[3]: %q, %v", records[3].Line, records[3].Fields)
+	}
+
+	// Clone 不含解码中的内容
+	if _, ok := c.Decode([]byte("2026-10-19T10:00:00.000000006Z stdout P a\n")); ok {
+		t.Fatal("it should is pending")
+	}
+	if c.Clone().Pending() != 0 {
+		t.Error("clone should not pending")
+	}
+}
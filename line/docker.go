@@ -0,0 +1,81 @@
+package line
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Docker docker json-file 的日志格式, 如: {"log":"msg\n","stream":"stderr","time":"2026-10-19T10:00:00.000000000Z"}
+// 说明:
+//  1. 解码后的行为 log 的内容, stream, time 放在 Record.Fields 中
+//  2. 超过 16K 的行会被拆分为多条记录, 只有最后一条的 log 以 \n 结尾, 这里会重新拼接, Fields 以第一条为准
+//  3. 格式不对的行原样输出
+type Docker struct {
+	raw     rawLine
+	buf     bytes.Buffer // 被拆分的行
+	fields  map[string]string
+	pending int // buf 对应的原始内容长度
+}
+
+type dockerLog struct {
+	Log    string `json:"log"`
+	Stream string `json:"stream"`
+	Time   string `json:"time"`
+}
+
+func NewDocker() *Docker {
+	return &Docker{}
+}
+
+func (d *Docker) Decode(row []byte) (*Record, bool) {
+	row, ok := d.raw.complete(row)
+	if !ok {
+		return nil, false
+	}
+	return d.decode(row)
+}
+
+func (d *Docker) Flush() (*Record, bool) {
+	if row, ok := d.raw.flush(); ok {
+		if record, ok := d.decode(row); ok {
+			return record, true
+		}
+	}
+	if d.buf.Len() == 0 {
+		return nil, false
+	}
+	return d.record(), true
+}
+
+func (d *Docker) Pending() int {
+	return d.raw.buf.Len() + d.pending
+}
+
+func (d *Docker) Clone() Decoder {
+	return NewDocker()
+}
+
+func (d *Docker) decode(row []byte) (*Record, bool) {
+	var l dockerLog
+	if err := json.Unmarshal(bytes.TrimSpace(row), &l); err != nil {
+		return &Record{Line: row}, true
+	}
+	if d.buf.Len() == 0 {
+		d.fields = map[string]string{"stream": l.Stream, "time": l.Time}
+	}
+	d.buf.WriteString(l.Log)
+	d.pending += len(row)
+	if !bytes.HasSuffix(d.buf.Bytes(), []byte("\n")) {
+		return nil, false
+	}
+	return d.record(), true
+}
+
+func (d *Docker) record() *Record {
+	record := &Record{Line: make([]byte, d.buf.Len()), Fields: d.fields}
+	copy(record.Line, d.buf.Bytes())
+	d.buf.Reset()
+	d.fields = nil
+	d.pending = 0
+	return record
+}
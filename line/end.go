@@ -0,0 +1,30 @@
+package line
+
+// End 多行处理, 直到匹配结束行的正则表达式才结束一行
+type End struct {
+	merge
+}
+
+func NewEnd() *End {
+	return &End{}
+}
+
+// EndPattern 行结束的正则表达式
+func (e *End) EndPattern(expr string) error {
+	return e.compile(expr)
+}
+
+func (e *End) Append(data []byte) bool {
+	if len(data) == 0 { // 文件读取结束时可能为空
+		return false
+	}
+	e.write(data)
+	if e.match(data) || e.full() {
+		e.flush()
+	}
+	return len(e.line) > 0
+}
+
+func (e *End) Clone() Merger {
+	return &End{merge: e.clone()}
+}
@@ -0,0 +1,149 @@
+package line
+
+import (
+	"bytes"
+)
+
+const (
+	defaultJSONMaxBytes = 1 << 20 // json 合并后默认最多的字节数
+)
+
+// JSON 多行处理, 将格式化输出的多行 json 合并为一行
+// 说明:
+//  1. 以 { 或 [ 开头, 或者以 { 或 [ 结尾的行开始合并, 直到括号闭合, 字符串中的括号不计入
+//  2. 其他的行按单行处理
+//  3. 格式不对(如: 括号一直没有闭合)时, 达到 MaxBytes(默认 1M) 或 MaxLines 后强制结束, 开始的行按单行输出, 之后的行重新判断
+//  4. 一次 Append 可能完成多行, 调用 Line 后需通过 More 判断是否还有
+type JSON struct {
+	merge
+	depth    int      // 括号的层级
+	inString bool     // 是否在字符串中
+	escape   bool     // 上一个字符是否为转义符
+	done     [][]byte // 已完成还未获取的行
+}
+
+func NewJSON() *JSON {
+	j := &JSON{}
+	j.maxBytes = defaultJSONMaxBytes
+	return j
+}
+
+func (j *JSON) Append(data []byte) bool {
+	if len(data) == 0 { // 文件读取结束时可能为空
+		return false
+	}
+	j.append(data)
+	return len(j.done) > 0
+}
+
+func (j *JSON) append(data []byte) {
+	scanData := data
+	if j.buf.Len() == 0 {
+		start := j.start(data)
+		if start < 0 { // 非 json, 按单行处理
+			j.done = append(j.done, j.copy(data))
+			return
+		}
+		scanData = data[start:] // json 之前的内容不计入, 如: request body: {
+	}
+	j.scan(scanData)
+	j.write(data)
+	if j.depth <= 0 {
+		j.reset()
+		j.flush()
+		j.done = append(j.done, j.merge.Line())
+		return
+	}
+	if j.full() {
+		j.fallback()
+	}
+}
+
+// fallback 格式不对达到上限时, 开始的行按单行输出, 之后的行重新判断
+func (j *JSON) fallback() {
+	rows := bytes.SplitAfter(j.copy(j.buf.Bytes()), []byte("\n"))
+	j.reset()
+	j.buf.Reset()
+	j.lines = 0
+	j.done = append(j.done, rows[0])
+	for _, row := range rows[1:] {
+		if len(row) > 0 {
+			j.append(row)
+		}
+	}
+}
+
+func (j *JSON) Line() []byte {
+	if len(j.done) == 0 {
+		return nil
+	}
+	tmp := j.done[0]
+	j.done[0] = nil
+	j.done = j.done[1:]
+	return tmp
+}
+
+func (j *JSON) More() bool {
+	return len(j.done) > 0
+}
+
+func (j *JSON) Null() bool {
+	j.reset()
+	if j.buf.Len() > 0 {
+		j.flush()
+		j.done = append(j.done, j.merge.Line())
+	}
+	return len(j.done) == 0
+}
+
+func (j *JSON) Clone() Merger {
+	return &JSON{merge: j.clone()}
+}
+
+// start json 开始的位置, 非 json 时返回 -1
+func (j *JSON) start(data []byte) int {
+	row := bytes.TrimSpace(data)
+	if len(row) == 0 {
+		return -1
+	}
+	if first, last := row[0], row[len(row)-1]; !isOpen(first) && !isOpen(last) {
+		return -1
+	}
+	return bytes.IndexAny(data, "{[")
+}
+
+// scan 计算括号的层级
+func (j *JSON) scan(data []byte) {
+	for _, c := range data {
+		if j.inString {
+			switch {
+			case j.escape:
+				j.escape = false
+			case c == '\\':
+				j.escape = true
+			case c == '"':
+				j.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			j.inString = true
+		case '{', '[':
+			j.depth++
+		case '}', ']':
+			j.depth--
+		}
+	}
+}
+
+func (j *JSON) reset() {
+	j.depth = 0
+	j.inString = false
+	j.escape = false
+}
+
+func isOpen(c byte) bool {
+	return c == '{' || c == '['
+}
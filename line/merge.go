@@ -0,0 +1,96 @@
+package line
+
+import (
+	"bytes"
+	"regexp"
+
+	plg "gitee.com/xuesongtao/ps-log/log"
+)
+
+// merge 多行合并的公共部分
+type merge struct {
+	re       *regexp.Regexp
+	negate   bool // 是否取反, true 时不匹配 re 的行按匹配处理
+	maxLines int  // 合并后最多的行数, 达到后强制结束, 0 为不限制
+	maxBytes int  // 合并后最多的字节数, 达到后强制结束, 0 为不限制
+	lines    int  // buf 中的行数
+	line     []byte
+	buf      bytes.Buffer
+}
+
+func (m *merge) compile(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	m.re = re
+	return nil
+}
+
+// Negate 是否取反, 如: 以 `^\[` 为起始行时, 取反后为不以 [ 开头的行为起始行
+func (m *merge) Negate(negate bool) {
+	m.negate = negate
+}
+
+// MaxLines 合并后最多的行数, 达到后强制结束, 防止一直没有结束行时内存过大
+func (m *merge) MaxLines(n int) {
+	m.maxLines = n
+}
+
+// MaxBytes 合并后最多的字节数, 达到后强制结束, 防止一直没有结束行时内存过大
+func (m *merge) MaxBytes(n int) {
+	m.maxBytes = n
+}
+
+func (m *merge) Null() bool {
+	m.flush()
+	return len(m.line) == 0
+}
+
+func (m *merge) Line() []byte {
+	tmp := m.line
+	m.line = nil
+	return tmp
+}
+
+func (m *merge) Pending() int {
+	return m.buf.Len()
+}
+
+// clone 复制规则, 不含合并中的内容
+func (m *merge) clone() merge {
+	return merge{re: m.re, negate: m.negate, maxLines: m.maxLines, maxBytes: m.maxBytes}
+}
+
+// match 是否匹配, 去掉行尾的换行后再匹配, 便于使用 $
+func (m *merge) match(data []byte) bool {
+	return m.re.Match(bytes.TrimRight(data, "\r\n")) != m.negate
+}
+
+// full 是否达到上限
+func (m *merge) full() bool {
+	if m.maxLines > 0 && m.lines >= m.maxLines {
+		return true
+	}
+	return m.maxBytes > 0 && m.buf.Len() >= m.maxBytes
+}
+
+// flush 结束合并, buf 中的内容作为 line
+func (m *merge) flush() {
+	m.line = m.copy(m.buf.Bytes())
+	m.buf.Reset()
+	m.lines = 0
+}
+
+func (m *merge) write(data []byte) {
+	if _, err := m.buf.Write(data); err != nil {
+		plg.Error("m.buf.Write is failed, err:", err)
+	}
+	m.lines++
+}
+
+func (m *merge) copy(src []byte) []byte {
+	tmp := make([]byte, len(src))
+	copy(tmp, src)
+	return tmp
+}
@@ -0,0 +1,248 @@
+package line
+
+import (
+	"strings"
+	"testing"
+)
+
+// mergeLines 按行追加, 返回合并后的行
+func mergeLines(m Merger, rows []string) []string {
+	res := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if m.Append([]byte(row + "\n")) {
+			res = append(res, string(m.Line()))
+		}
+		for More(m) {
+			res = append(res, string(m.Line()))
+		}
+	}
+	if !m.Null() {
+		res = append(res, string(m.Line()))
+	}
+	for More(m) {
+		res = append(res, string(m.Line()))
+	}
+	return res
+}
+
+func TestMulti(t *testing.T) {
+	m := NewMulti()
+	if err := m.StartPattern(`^\[`); err != nil {
+		t.Fatal(err)
+	}
+	got := mergeLines(m, []string{"[ERRO] a", "stack 1", "[INFO] b", "[ERRO] c", "stack 2"})
+	want := []string{"[ERRO] a\nstack 1\n", "[INFO] b\n", "[ERRO] c\nstack 2\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestContinue(t *testing.T) {
+	c := NewContinue()
+	if err := c.ContinuePattern(`^(\s+|at |Caused by:)`); err != nil {
+		t.Fatal(err)
+	}
+	got := mergeLines(c, []string{
+		"Exception in thread main",
+		"	at com.a.B.c(B.java:1)",
+		"Caused by: java.lang.NullPointerException",
+		"	at com.a.B.d(B.java:2)",
+		"2026-10-19 INFO ok",
+	})
+	if len(got) != 2 || strings.Count(got[0], "\n") != 4 || got[1] != "2026-10-19 INFO ok\n" {
+		t.Errorf("got: %q", got)
+	}
+
+	// 取反, 不以 [ 开头的为续行
+	c = NewContinue()
+	if err := c.ContinuePattern(`^\[`); err != nil {
+		t.Fatal(err)
+	}
+	c.Negate(true)
+	got = mergeLines(c, []string{"[ERRO] a", "stack 1", "[INFO] b"})
+	if len(got) != 2 || got[0] != "[ERRO] a\nstack 1\n" {
+		t.Errorf("negate got: %q", got)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	e := NewEnd()
+	if err := e.EndPattern(`;$`); err != nil {
+		t.Fatal(err)
+	}
+	got := mergeLines(e, []string{"select *", "from t;", "update t", "set a = 1", "where b = 2;", "delete"})
+	want := []string{"select *\nfrom t;\n", "update t\nset a = 1\nwhere b = 2;\n", "delete\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestMergeMax(t *testing.T) {
+	m := NewMulti()
+	if err := m.StartPattern(`^\[`); err != nil {
+		t.Fatal(err)
+	}
+	m.MaxLines(2)
+	got := mergeLines(m, []string{"[ERRO] a", "stack 1", "stack 2", "stack 3"})
+	if len(got) != 2 || got[0] != "[ERRO] a\nstack 1\n" {
+		t.Errorf("max lines got: %q", got)
+	}
+
+	e := NewEnd()
+	if err := e.EndPattern(`^end$`); err != nil {
+		t.Fatal(err)
+	}
+	e.MaxBytes(10)
+	got = mergeLines(e, []string{"12345", "67890", "end"})
+	if len(got) != 2 || got[0] != "12345\n67890\n" {
+		t.Errorf("max bytes got: %q", got)
+	}
+
+	// Clone 保留规则, 不含合并中的内容
+	m.Append([]byte("[ERRO] b\n"))
+	clone := m.Clone()
+	if clone.Pending() != 0 {
+		t.Errorf("clone pending: %d", clone.Pending())
+	}
+	got = mergeLines(clone, []string{"[ERRO] c", "stack 1", "stack 2"})
+	if len(got) != 2 {
+		t.Errorf("clone got: %q", got)
+	}
+}
+
+func TestStack(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []string
+		want int // 合并后的行数
+	}{
+		{
+			name: "java",
+			rows: []string{
+				"2026-10-19 10:00:00 ERROR request failed",
+				"java.lang.IllegalStateException: boom",
+				"	at com.a.B.c(B.java:1)",
+				"Caused by: java.lang.NullPointerException",
+				"	at com.a.B.d(B.java:2)",
+				"	... 5 more",
+				"2026-10-19 10:00:01 INFO ok",
+			},
+			want: 2,
+		},
+		{
+			name: "python",
+			rows: []string{
+				"2026-10-19 10:00:00 ERROR request failed",
+				"Traceback (most recent call last):",
+				`  File "app.py", line 1, in <module>`,
+				"    main()",
+				"ValueError: bad value",
+				"",
+				"During handling of the above exception, another exception occurred:",
+				"",
+				"Traceback (most recent call last):",
+				`  File "app.py", line 3, in <module>`,
+				"KeyError: 'a'",
+				"2026-10-19 10:00:01 INFO ok",
+			},
+			want: 2,
+		},
+		{
+			name: "go",
+			rows: []string{
+				"panic: runtime error: index out of range [1] with length 0",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"	/app/main.go:5 +0x1d",
+				"created by net/http.(*Server).Serve in goroutine 1",
+				"	/usr/local/go/src/net/http/server.go:3285 +0x4b4",
+				"exit status 2",
+				"2026/10/19 10:00:01 [INFO] ok",
+			},
+			want: 2,
+		},
+		{
+			name: "node",
+			rows: []string{
+				"Error: boom",
+				"    at Object.<anonymous> (/app/index.js:1:7)",
+				"    at Module._compile (node:internal/modules/cjs/loader:1105:14)",
+				"2026-10-19T10:00:01.000Z info ok",
+			},
+			want: 2,
+		},
+		{
+			name: "node type error",
+			rows: []string{
+				"2026-10-19T10:00:00.000Z error request failed",
+				"TypeError: Cannot read properties of undefined (reading 'id')",
+				"    at handler (/app/index.js:10:15)",
+				"2026-10-19T10:00:01.000Z info ok",
+			},
+			want: 2,
+		},
+		{
+			// 普通的以 ) 结尾的行不是堆栈
+			name: "plain",
+			rows: []string{
+				"call foo(bar)",
+				"main.go started (pid 1)",
+				"user(1) login failed (timeout)",
+				"created by admin",
+				"handler.Serve(ctx)",
+			},
+			want: 5,
+		},
+	}
+	for _, tt := range tests {
+		got := mergeLines(NewStack(), tt.rows)
+		if len(got) != tt.want {
+			t.Errorf("%s got: %q", tt.name, got)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	j := NewJSON()
+	got := mergeLines(j, []string{
+		"2026-10-19 INFO start",
+		"{",
+		`  "msg": "a } b",`,
+		`  "esc": "c \" { d",`,
+		`  "list": [1, 2],`,
+		`  "obj": {"k": "v"}`,
+		"}",
+		`{"a": 1}`,
+		"request body: [",
+		"  1",
+		"]",
+	})
+	want := []string{
+		"2026-10-19 INFO start\n",
+		"{\n  \"msg\": \"a } b\",\n  \"esc\": \"c \\\" { d\",\n  \"list\": [1, 2],\n  \"obj\": {\"k\": \"v\"}\n}\n",
+		"{\"a\": 1}\n",
+		"request body: [\n  1\n]\n",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	// 格式不对时, 达到上限后开始的行按单行输出, 之后的行重新判断
+	j = NewJSON()
+	j.MaxLines(3)
+	got = mergeLines(j, []string{"{", `  "a": 1,`, `  "b": 2,`, "INFO next"})
+	want = []string{"{\n", "  \"a\": 1,\n", "  \"b\": 2,\n", "INFO next\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("malformed got: %q", got)
+	}
+
+	// 之后的行可以开始新的 json
+	j = NewJSON()
+	j.MaxLines(3)
+	got = mergeLines(j, []string{"[", "{", `  "a": 1`, "}", "INFO next"})
+	want = []string{"[\n", "{\n  \"a\": 1\n}\n", "INFO next\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("refeed got: %q", got)
+	}
+}
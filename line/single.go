@@ -26,3 +26,11 @@ func (s *Single) Append(data []byte) bool {
 	s.line = data
 	return true
 }
+
+func (s *Single) Pending() int {
+	return 0
+}
+
+func (s *Single) Clone() Merger {
+	return NewSing()
+}
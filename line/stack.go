@@ -0,0 +1,80 @@
+package line
+
+import (
+	"bytes"
+	"regexp"
+)
+
+var (
+	// stackRe 常见的堆栈行
+	stackRe = regexp.MustCompile(`^(` +
+		// java: Caused by: xxx, ... 5 more, java.lang.NullPointerException: xxx
+		`(Caused by|Suppressed): |\.\.\. \d+ (more|common frames omitted)|([a-zA-Z_$][\w$]*\.)+[a-zA-Z_$][\w$]*(Exception|Error|Throwable)(: .*)?$|` +
+		// go: exit status 2, [signal SIGSEGV: xxx], 函数行见 goFrameRe
+		`exit status \d+$|\[signal |` +
+		// node: TypeError: xxx, 其后的 "    at xxx" 以空白开头
+		`[A-Z]\w*Error(: .*)?$|` +
+		// python: 链式异常的说明
+		`During handling of the above exception|The above exception was the direct cause` +
+		`)`)
+	// tracebackRe python 的堆栈开始
+	tracebackRe = regexp.MustCompile(`^Traceback \(most recent call last\):`)
+	// goroutineRe go 的堆栈开始, 如: goroutine 1 [running]:
+	goroutineRe = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+	// goFrameRe go 堆栈中不以空白开头的函数行, 如: main.main(), created by xxx, 只在 goroutine 中判断, 防止误判普通的行
+	goFrameRe = regexp.MustCompile(`^([\w./*()-]+\(.*\)|created by .+)$`)
+)
+
+// Stack 多行处理, 自动识别常见的堆栈(java, python, go, node), 合并到上一行
+// 说明: 以空白开头的行, 空行以及常见的堆栈行都会追加到上一行
+type Stack struct {
+	merge
+	traceback bool // 是否在 python 的 Traceback 中, 其后第一个不以空白开头的行为异常信息
+	goroutine bool // 是否在 go 的 goroutine 堆栈中
+}
+
+func NewStack() *Stack {
+	return &Stack{}
+}
+
+func (s *Stack) Append(data []byte) bool {
+	if len(data) == 0 { // 文件读取结束时可能为空
+		return false
+	}
+	if !s.isStack(data) || s.full() {
+		s.flush()
+		s.traceback = false
+	}
+	s.write(data)
+	return len(s.line) > 0
+}
+
+func (s *Stack) Clone() Merger {
+	return &Stack{merge: s.clone()}
+}
+
+// isStack 是否为堆栈行
+func (s *Stack) isStack(data []byte) bool {
+	row := bytes.TrimRight(data, "\r\n")
+	if len(bytes.TrimSpace(row)) == 0 || row[0] == ' ' || row[0] == '\t' {
+		return true
+	}
+	if tracebackRe.Match(row) {
+		s.traceback = true
+		return true
+	}
+	if s.traceback {
+		// 如: ValueError: xxx
+		s.traceback = false
+		return true
+	}
+	if goroutineRe.Match(row) {
+		s.goroutine = true
+		return true
+	}
+	if s.goroutine && goFrameRe.Match(row) {
+		return true
+	}
+	s.goroutine = false
+	return stackRe.Match(row)
+}
@@ -2,8 +2,11 @@ package pslog
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	xf "gitee.com/xuesongtao/gotool/xfile"
@@ -15,9 +18,15 @@ const (
 )
 
 type LogDir struct {
-	Namespace   string   // log 的命名空间
-	Name        string   // 项目中 log 的目录名, 为空时, 默认 defaultLogDir
-	TargetNames []string // 目标文件名
+	Namespace       string   // log 的命名空间
+	Name            string   // 项目中 log 的目录名, 为空时, 默认 defaultLogDir
+	TargetNames     []string // 目标文件名
+	TargetPatterns  []string // 目标文件名的正则, 如: `^app-\d{4}-\d{2}-\d{2}\.log$`
+	ExcludePatterns []string // 排除的文件名的正则, 只对 TargetPatterns 匹配到的文件生效
+	NewestOnly      bool     // TargetPatterns 中每个正则匹配到多个文件时, 只保留最新(修改时间)的一个, 常用于按日期切割的文件
+
+	targets  []*regexp.Regexp
+	excludes []*regexp.Regexp
 }
 
 func (l *LogDir) init() {
@@ -35,12 +44,85 @@ func (l *LogDir) valid() error {
 		return errors.New("name is null")
 	}
 
-	if len(l.TargetNames) == 0 {
-		return errors.New("targetNames is null")
+	if len(l.TargetNames) == 0 && len(l.TargetPatterns) == 0 {
+		return errors.New("targetNames, targetPatterns can not both null")
+	}
+
+	var err error
+	if l.targets, err = compilePatterns(l.TargetPatterns); err != nil {
+		return fmt.Errorf("targetPatterns is not ok, err: %v", err)
+	}
+	if l.excludes, err = compilePatterns(l.ExcludePatterns); err != nil {
+		return fmt.Errorf("excludePatterns is not ok, err: %v", err)
 	}
 	return nil
 }
 
+// matchTarget 判断文件名是否匹配 TargetPatterns, 且没有被 ExcludePatterns 排除
+func (l *LogDir) matchTarget(filename string) bool {
+	name := filepath.Base(filename)
+	return matchPatterns(l.targets, name) && !matchPatterns(l.excludes, name)
+}
+
+// parseTargets 解析 log 目录下匹配 TargetPatterns 的文件名
+func (l *LogDir) parseTargets(logDir string) []string {
+	if len(l.targets) == 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(logDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			plg.Errorf("ioutil.ReadDir %q is failed, err: %v", logDir, err)
+		}
+		return nil
+	}
+
+	res := make([]string, 0)
+	for _, target := range l.targets {
+		var newest os.FileInfo
+		for _, file := range files {
+			if file.IsDir() || !target.MatchString(file.Name()) || matchPatterns(l.excludes, file.Name()) {
+				continue
+			}
+			if !l.NewestOnly {
+				res = append(res, file.Name())
+				continue
+			}
+			if newest == nil || file.ModTime().After(newest.ModTime()) ||
+				(file.ModTime().Equal(newest.ModTime()) && file.Name() > newest.Name()) {
+				newest = file
+			}
+		}
+		if newest != nil {
+			res = append(res, newest.Name())
+		}
+	}
+	return res
+}
+
+// compilePatterns 编译正则
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not ok, err: %v", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// matchPatterns 有一个正则匹配即可
+func matchPatterns(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
 // ProjectSrc 项目根目录
 type ProjectSrc struct {
 	LogDir  *LogDir
@@ -55,12 +137,18 @@ type Project struct {
 
 type ProjectLog struct {
 	ProjectName string
-	Namespace   string // log 的命名空间
-	LogPath     string // 项目的 log path
+	Namespace   string            // log 的命名空间
+	LogPath     string            // 项目的 log path
+	Labels      map[string]string // 标签, 包含 LogPath 设置的标签及 project, namespace, 通过 PsLog.AddProjectLogs 添加时会带到 LogHandlerBus.Labels 中
+
+	needCollect func(filename string) bool // LogPath 为 log 目录时, 判断文件是否需要采集
 }
 
 type LogPath struct {
-	excludeProjectDir map[string]bool // 排除的项目路径
+	excludeProjectDir map[string]bool   // 排除的项目路径
+	includeProjects   []*regexp.Regexp  // 项目名需要匹配的正则, 为空时不限制
+	excludeProjects   []*regexp.Regexp  // 排除的项目名的正则
+	labels            map[string]string // 自定义的标签
 }
 
 func NewLogPath() *LogPath {
@@ -104,8 +192,11 @@ func (l *LogPath) ParseSrc(src *ProjectSrc) []*ProjectLog {
 		appName := projectDir.Name() // 项目名
 		logPaths := l.ParseLogPath(&Project{
 			LogDir: &LogDir{
-				Namespace:   src.LogDir.Namespace,
-				TargetNames: src.LogDir.TargetNames,
+				Namespace:       src.LogDir.Namespace,
+				TargetNames:     src.LogDir.TargetNames,
+				TargetPatterns:  src.LogDir.TargetPatterns,
+				ExcludePatterns: src.LogDir.ExcludePatterns,
+				NewestOnly:      src.LogDir.NewestOnly,
 			},
 			ProjectPath: filepath.Join(src.SrcPath, appName),
 		})
@@ -115,6 +206,7 @@ func (l *LogPath) ParseSrc(src *ProjectSrc) []*ProjectLog {
 }
 
 // ParseLogPaths 解析项目 log path
+// 说明: TargetNames 为存在的文件, TargetPatterns 为 log 目录下匹配的文件, NewestOnly 时每个正则只返回最新的一个
 func (l *LogPath) ParseLogPath(project *Project) []*ProjectLog {
 	if l.excludePath(project.ProjectPath) {
 		return nil
@@ -131,20 +223,40 @@ func (l *LogPath) ParseLogPath(project *Project) []*ProjectLog {
 
 	projectLogPaths := make([]*ProjectLog, 0, len(project.LogDir.TargetNames)) // 解析所有目标文件
 	// 解析目标文件
-	for _, targetName := range project.LogDir.TargetNames {
-		logPath := filepath.Join(project.ProjectPath, project.LogDir.Name, targetName)
-		if !xf.Exists(logPath) {
+	logDir := filepath.Join(project.ProjectPath, project.LogDir.Name)
+	exists := make(map[string]bool, len(project.LogDir.TargetNames))
+	targetNames := append(append([]string{}, project.LogDir.TargetNames...), project.LogDir.parseTargets(logDir)...)
+	for _, targetName := range targetNames {
+		logPath := filepath.Join(logDir, targetName)
+		if exists[logPath] || !xf.Exists(logPath) {
 			continue
 		}
-		projectLogPaths = append(projectLogPaths, &ProjectLog{
-			ProjectName: filepath.Base(project.ProjectPath),
-			Namespace:   project.LogDir.Namespace,
-			LogPath:     logPath,
-		})
+		exists[logPath] = true
+		projectLogPaths = append(projectLogPaths, l.newProjectLog(project, targetName))
 	}
 	return projectLogPaths
 }
 
+// newProjectLog 项目的目标文件
+func (l *LogPath) newProjectLog(project *Project, targetName string) *ProjectLog {
+	projectName := filepath.Base(project.ProjectPath)
+	return &ProjectLog{
+		ProjectName: projectName,
+		Namespace:   project.LogDir.Namespace,
+		LogPath:     filepath.Join(project.ProjectPath, project.LogDir.Name, targetName),
+		Labels: mergeLabels(l.labels, map[string]string{
+			LabelProject:   projectName,
+			LabelNamespace: project.LogDir.Namespace,
+		}),
+	}
+}
+
+// SetLabels 设置自定义的标签, 会带到解析出的每个 ProjectLog.Labels 中
+// 注: project, namespace 以解析的为准
+func (l *LogPath) SetLabels(labels map[string]string) {
+	l.labels = mergeLabels(l.labels, labels)
+}
+
 // SetExcludeProjectDir 设置排除的项目目录
 func (l *LogPath) SetExcludeProjectDir(paths ...string) {
 	if l.excludeProjectDir == nil {
@@ -156,12 +268,36 @@ func (l *LogPath) SetExcludeProjectDir(paths ...string) {
 	}
 }
 
+// SetIncludeProjectPattern 设置项目名需要匹配的正则, 有一个匹配即可, 如: `^order-`
+func (l *LogPath) SetIncludeProjectPattern(patterns ...string) error {
+	res, err := compilePatterns(patterns)
+	if err != nil {
+		return err
+	}
+	l.includeProjects = append(l.includeProjects, res...)
+	return nil
+}
+
+// SetExcludeProjectPattern 设置排除的项目名的正则, 有一个匹配即排除, 如: `-bak$`
+func (l *LogPath) SetExcludeProjectPattern(patterns ...string) error {
+	res, err := compilePatterns(patterns)
+	if err != nil {
+		return err
+	}
+	l.excludeProjects = append(l.excludeProjects, res...)
+	return nil
+}
+
 // excludePath 判断是否被排除
 func (l *LogPath) excludePath(projectDir string) bool {
-	if len(l.excludeProjectDir) == 0 {
-		return false
-	}
 	projectDir = strings.TrimRight(projectDir, "/")
-	_, ok := l.excludeProjectDir[projectDir]
-	return ok
+	if _, ok := l.excludeProjectDir[projectDir]; ok {
+		return true
+	}
+
+	projectName := filepath.Base(projectDir)
+	if len(l.includeProjects) > 0 && !matchPatterns(l.includeProjects, projectName) {
+		return true
+	}
+	return matchPatterns(l.excludeProjects, projectName)
 }
@@ -1,7 +1,13 @@
 package pslog
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"gitee.com/xuesongtao/gotool/base"
 )
@@ -21,3 +27,86 @@ func TestParseLogPath1(t *testing.T) {
 	path = append(path, res...)
 	t.Log(base.ToString(path))
 }
+
+func TestParseLogPathPattern(t *testing.T) {
+	src := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{
+		"demo/log/app-2026-10-15.log",
+		"demo/log/app-2026-10-17.log",
+		"demo/log/app-2026-10-16.log",
+		"demo/log/app-2026-10-16.log.gz",
+		"demo/log/app.log",
+		"demo-bak/log/app.log",
+		"other/log/app.log",
+	} {
+		filename := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i) * time.Second)
+		if strings.Contains(name, "10-17") {
+			modTime = now.Add(time.Hour)
+		}
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getNames := func(logs []*ProjectLog) []string {
+		res := make([]string, 0, len(logs))
+		for _, v := range logs {
+			rel, _ := filepath.Rel(src, v.LogPath)
+			res = append(res, rel)
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	obj := NewLogPath()
+	if err := obj.SetIncludeProjectPattern(`^demo`); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.SetExcludeProjectPattern(`-bak$`); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.SetExcludeProjectPattern(`(`); err == nil {
+		t.Error("pattern should is not ok")
+	}
+
+	logDir := &LogDir{
+		Namespace:       "ns",
+		TargetNames:     []string{"app.log"},
+		TargetPatterns:  []string{`^app-\d{4}-\d{2}-\d{2}\.log`},
+		ExcludePatterns: []string{`\.gz$`},
+	}
+	got := getNames(obj.ParseSrc(&ProjectSrc{LogDir: logDir, SrcPath: src}))
+	want := []string{
+		"demo/log/app-2026-10-15.log",
+		"demo/log/app-2026-10-16.log",
+		"demo/log/app-2026-10-17.log",
+		"demo/log/app.log",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	// 只保留最新的
+	logDir.NewestOnly = true
+	got = getNames(obj.ParseSrc(&ProjectSrc{LogDir: logDir, SrcPath: src}))
+	want = []string{"demo/log/app-2026-10-17.log", "demo/log/app.log"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	logs := obj.ParseLogPath(&Project{
+		LogDir:      &LogDir{Namespace: "ns", TargetPatterns: []string{`[`}},
+		ProjectPath: filepath.Join(src, "demo"),
+	})
+	if len(logs) != 0 {
+		t.Errorf("logs: %v", getNames(logs))
+	}
+}
@@ -0,0 +1,204 @@
+package pslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	plg "gitee.com/xuesongtao/ps-log/log"
+	fs "github.com/fsnotify/fsnotify"
+)
+
+const (
+	autoPollMisses = 2 // 自动轮询时, 有变化但连续未收到事件的次数阈值
+)
+
+// pollState 轮询的 path 状态
+type pollState struct {
+	files  map[string]os.FileInfo // 上一次轮询的文件状态, key: 文件全路径
+	misses int                    // 自动轮询时, 有变化但未收到事件的连续次数
+	event  bool                   // 自动轮询时, 两次轮询之间是否收到了事件
+}
+
+func newPollState(info *WatchFileInfo) *pollState {
+	files, _ := scanPoll(info)
+	return &pollState{files: files}
+}
+
+// SetPoll 设置已添加的 path 为轮询, 用于不支持 inotify 的文件系统(如: NFS)
+func (w *Watch) SetPoll(paths ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if info, ok := w.pendingMap[path]; ok {
+			info.Poll = true
+			continue
+		}
+		info, ok := w.fileMap[path]
+		if !ok {
+			return fmt.Errorf("%q is not watched", path)
+		}
+		info.Poll = true
+		if _, ok := w.pollMap[path]; !ok {
+			w.pollMap[path] = newPollState(info)
+		}
+	}
+	return nil
+}
+
+// markEvent 记录 path 收到了事件, 用于自动轮询
+func (w *Watch) markEvent(path string) {
+	if !w.autoPoll {
+		return
+	}
+	w.mu.Lock()
+	if state, ok := w.pollMap[path]; ok {
+		state.event = true
+	}
+	w.mu.Unlock()
+}
+
+// poll 定时轮询, 发送的内容和 fsnotify 的一致
+func (w *Watch) poll(busCh chan *WatchFileInfo) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer func() {
+		ticker.Stop()
+		w.pollWg.Done()
+	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.pollOnce(busCh)
+		case <-w.closeCh:
+			plg.Info("watch poll is close")
+			return
+		}
+	}
+}
+
+// pollOnce 轮询一次
+func (w *Watch) pollOnce(busCh chan *WatchFileInfo) {
+	w.mu.RLock()
+	pendings := make([]string, 0)
+	for path, info := range w.pendingMap {
+		if info.Poll || w.autoPoll {
+			pendings = append(pendings, path)
+		}
+	}
+	infos := make([]*WatchFileInfo, 0, len(w.pollMap))
+	for path := range w.pollMap {
+		if info, ok := w.fileMap[path]; ok {
+			infos = append(infos, info.copy())
+		}
+	}
+	w.mu.RUnlock()
+
+	for _, path := range pendings {
+		if _, err := os.Stat(path); err == nil {
+			w.emitActivated(busCh, path)
+		}
+	}
+	for _, info := range infos {
+		for _, event := range w.pollDiff(info) {
+			w.dispatch(busCh, info.copy(), event.Op, event.Name)
+		}
+	}
+}
+
+// pollDiff 对比上一次轮询的文件状态, 返回变化的事件
+// 说明: 自动轮询时, 有变化但连续 autoPollMisses 次未收到事件, 会改为轮询
+func (w *Watch) pollDiff(info *WatchFileInfo) []fs.Event {
+	files, exist := scanPoll(info)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state, ok := w.pollMap[info.Path]
+	if !ok {
+		return nil
+	}
+	if !exist {
+		if info.Poll {
+			return []fs.Event{{Name: info.Path, Op: fs.Remove}}
+		}
+		return nil
+	}
+
+	events := diffPoll(state.files, files)
+	state.files = files
+	if info.Poll {
+		return events
+	}
+
+	if state.event {
+		state.misses = 0
+	} else if len(events) > 0 {
+		state.misses++
+	}
+	state.event = false
+	if state.misses < autoPollMisses {
+		return nil
+	}
+	plg.Warningf("%q has changed but no event, it will poll", info.Path)
+	if tmp, ok := w.fileMap[info.Path]; ok {
+		tmp.Poll = true
+	}
+	info.Poll = true
+	return events
+}
+
+// diffPoll 对比文件状态
+// 说明:
+//  1. 新增, 文件 inode 变化, 文件变小(被清空)的为 Create, 会从头开始采集
+//  2. 大小或修改时间变化的为 Write
+//  3. 不存在的为 Remove
+func diffPoll(prev, cur map[string]os.FileInfo) []fs.Event {
+	events := make([]fs.Event, 0)
+	for name, st := range cur {
+		old, ok := prev[name]
+		switch {
+		case !ok || !os.SameFile(old, st) || st.Size() < old.Size():
+			events = append(events, fs.Event{Name: name, Op: fs.Create})
+		case st.Size() != old.Size() || !st.ModTime().Equal(old.ModTime()):
+			events = append(events, fs.Event{Name: name, Op: fs.Write})
+		}
+	}
+	for name := range prev {
+		if _, ok := cur[name]; !ok {
+			events = append(events, fs.Event{Name: name, Op: fs.Remove})
+		}
+	}
+	return events
+}
+
+// scanPoll 查询 path 下的文件状态, 目录会按 Recursive, MaxDepth 遍历, 并跳过 saveOffsetDir
+func scanPoll(info *WatchFileInfo) (map[string]os.FileInfo, bool) {
+	files := make(map[string]os.FileInfo)
+	st, err := os.Stat(info.Path)
+	if err != nil {
+		return files, false
+	}
+	if !st.IsDir() {
+		files[info.Path] = st
+		return files, true
+	}
+
+	filepath.Walk(info.Path, func(path string, st os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !st.IsDir() {
+			files[path] = st
+			return nil
+		}
+		if path == info.Path {
+			return nil
+		}
+		if !info.Recursive || st.Name() == saveOffsetDir || !dirInDepth(info.Path, path, info.MaxDepth) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return files, true
+}
@@ -0,0 +1,81 @@
+package pslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitee.com/xuesongtao/gotool/xfile"
+	fs "github.com/fsnotify/fsnotify"
+)
+
+func TestDiffPoll(t *testing.T) {
+	dir := t.TempDir()
+	info := &WatchFileInfo{Path: dir}
+	xfile.AppendContent(filepath.Join(dir, "a.log"), "a\n")
+	xfile.AppendContent(filepath.Join(dir, "b.log"), "b\n")
+	xfile.AppendContent(filepath.Join(dir, "c.log"), "c\n")
+	prev, _ := scanPoll(info)
+
+	xfile.AppendContent(filepath.Join(dir, "a.log"), "a\n")
+	xfile.PutContent(filepath.Join(dir, "b.log"), "")
+	os.Remove(filepath.Join(dir, "c.log"))
+	xfile.AppendContent(filepath.Join(dir, "d.log"), "d\n")
+	cur, _ := scanPoll(info)
+
+	got := make(map[string]fs.Op)
+	for _, event := range diffPoll(prev, cur) {
+		got[filepath.Base(event.Name)] = event.Op
+	}
+	want := map[string]fs.Op{"a.log": fs.Write, "b.log": fs.Create, "c.log": fs.Remove, "d.log": fs.Create}
+	for name, op := range want {
+		if got[name] != op {
+			t.Errorf("%s want: %s, got: %s", name, op, got[name])
+		}
+	}
+}
+
+func TestTailPoll(t *testing.T) {
+	ps, _ := NewPsLog(WithPollInterval(50 * time.Millisecond))
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	tmp := filepath.Join(t.TempDir(), "app.log")
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		Poll:     true,     // 轮询
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+	}
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+	// 轮询时不需要 inotify
+	ps.watch.watcher.Remove(filepath.Dir(tmp))
+	t.Log(ps.watch.WatchList())
+
+	for i := 0; i < 3; i++ {
+		if _, err := xfile.AppendContent(tmp, "warning\n"); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, time.Second, func() bool { return strings.Count(strBuf.String(), "warning") == i+1 })
+	}
+
+	if got := strBuf.String(); strings.Count(got, "warning") != 3 {
+		t.Errorf("got: %q", got)
+	}
+}
@@ -2,6 +2,7 @@ package pslog
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,8 +14,11 @@ import (
 	"time"
 
 	"gitee.com/xuesongtao/gotool/base"
+	xf "gitee.com/xuesongtao/gotool/xfile"
+	"gitee.com/xuesongtao/ps-log/line"
 	plg "gitee.com/xuesongtao/ps-log/log"
 	tl "gitee.com/xuesongtao/taskpool"
+	fs "github.com/fsnotify/fsnotify"
 	tw "github.com/olekukonko/tablewriter"
 )
 
@@ -50,6 +54,35 @@ func WithCleanUpTime(dur time.Duration) Opt {
 	}
 }
 
+// WithPollInterval 设置轮询间隔, 用于 Handler.Poll 和自动轮询的 path
+func WithPollInterval(dur time.Duration) Opt {
+	return func(pl *PsLog) {
+		pl.watchOpts = append(pl.watchOpts, WithWatchPollInterval(dur))
+	}
+}
+
+// WithAutoPoll 开启自动轮询, 实时监听的 path 有变化但连续收不到 inotify 事件时(如: NFS, 部分 overlay/FUSE), 改为轮询
+// 说明: inotify 监听失败时, 不论是否开启都会改为轮询
+func WithAutoPoll() Opt {
+	return func(pl *PsLog) {
+		pl.watchOpts = append(pl.watchOpts, WithWatchAutoPoll())
+	}
+}
+
+// WithOverflowPolicy 设置监听到的事件处理不过来时的策略, 默认 OverflowBlock, 事件丢失后会全量扫描
+func WithOverflowPolicy(policy OverflowPolicy) Opt {
+	return func(pl *PsLog) {
+		pl.watchOpts = append(pl.watchOpts, WithWatchOverflow(policy))
+	}
+}
+
+// WithTailInterval 设置实时解析同一个文件的最小间隔, 间隔内的变化会合并为一次解析, 默认 0 不限制
+func WithTailInterval(dur time.Duration) Opt {
+	return func(pl *PsLog) {
+		pl.tailInterval = dur
+	}
+}
+
 // PsLog 解析 log
 type PsLog struct {
 	tail          bool          // 是否已开启实时分析
@@ -57,10 +90,14 @@ type PsLog struct {
 	firstCallList bool          // 标记是否第一调用 List
 	closed        int32         // 0-开 1-关
 	cleanUpTime   time.Duration // 清理 logMap 的周期
+	tailInterval  time.Duration // 实时解析同一个文件的最小间隔
 	rwMu          sync.RWMutex
+	submitMu      sync.RWMutex        // 保证关闭后不再向 taskPool 提交任务
 	taskPool      *tl.TaskPool        // 任务池
+	writePool     *tl.TaskPool        // 异步处理 tos 的任务池
 	handler       *Handler            // 处理部分
 	watch         *Watch              // 文件监听
+	watchOpts     []WatchOpt          // 文件监听的配置
 	watchCh       chan *WatchFileInfo // 文件监听文件内容
 	closeCh       chan struct{}
 	logMap        map[string]*FileInfo // key: 文件路径, 注: 里面包含 文件/目录
@@ -89,6 +126,9 @@ func NewPsLog(opts ...Opt) (*PsLog, error) {
 	if obj.taskPool == nil {
 		obj.taskPool = tl.NewTaskPool("parse log", runtime.NumCPU(), tl.WithPoolLogger(plg.Plg), tl.WithWorkerMaxLifeCycle(taskPoolWorkMaxLifetime))
 	}
+	if obj.async2Tos {
+		obj.writePool = tl.NewTaskPool("write tos", runtime.NumCPU(), tl.WithPoolLogger(plg.Plg), tl.WithWorkerMaxLifeCycle(taskPoolWorkMaxLifetime))
+	}
 
 	go obj.sentry()
 	plg.Info("init ps-log is success")
@@ -119,6 +159,7 @@ func (p *PsLog) AddPath2Handler(path string, handler *Handler) error {
 
 // ReplacePath2Handler 新增文件对应的处理方法, 如果 path 已存在则替换, 反之新增
 // 会根据文件对应的 Handler 进行处理, 如果为 Handler 为 nil, 会按 p.handler 来处理
+// 说明: 替换时保留偏移量和文件句柄, 旧 handler 合并中的内容会先输出; 附加的 handler 不会保留, 需要时替换后再附加
 func (p *PsLog) ReplacePath2Handler(path string, handler *Handler) error {
 	return p.addLogPath(map[string]*Handler{path: handler}, false)
 }
@@ -134,6 +175,157 @@ func (p *PsLog) AddDir2Handle(dir string, handler *Handler) error {
 	return p.addLogPath(map[string]*Handler{dir: handler})
 }
 
+// AddProjectLogs 添加 LogPath 解析出的项目 log, 如果 path 已存在则跳过, 反之新增
+// 说明:
+//  1. 每个 path 使用 handler 的副本, 并带上 ProjectLog.Labels, 同名的标签以 ProjectLog.Labels 为准
+//  2. handler 为 nil 时, 会按 p.handler 来处理
+func (p *PsLog) AddProjectLogs(handler *Handler, projectLogs ...*ProjectLog) error {
+	if handler == nil {
+		handler = p.handler
+	}
+	if handler == nil {
+		return errors.New("no has handler")
+	}
+	path2HandlerMap := make(map[string]*Handler, len(projectLogs))
+	for _, projectLog := range projectLogs {
+		tmp := handler.copy()
+		tmp.Labels = mergeLabels(tmp.Labels, projectLog.Labels)
+		if projectLog.needCollect != nil {
+			tmp.NeedCollect = projectLog.needCollect
+		}
+		path2HandlerMap[projectLog.LogPath] = tmp
+	}
+	return p.addLogPath(path2HandlerMap)
+}
+
+// AttachPath2Handler 为 path 附加 handler, 如果 path 不存在则新增
+// 说明:
+//  1. 同一 path 上的多个 handler 共用文件的读取和偏移量, 每行内容会分发给各个 handler 独立处理(Targets, MergeRule, Ext)
+//  2. Tail, Change, ExpireDur 等文件级的配置以最先添加的 handler 为准
+//  3. path 为目录时, handler.NeedCollect 必填, 文件只分发给需要采集它的 handler
+func (p *PsLog) AttachPath2Handler(path string, handler *Handler) error {
+	if handler == nil {
+		return fmt.Errorf("%q handler is nil", path)
+	}
+	path = filepath.Clean(path)
+	new, err := p.prePath2Handler(map[string]*Handler{path: handler})
+	if err != nil {
+		return err
+	}
+
+	// 查找和附加需在同一个锁内, 防止期间 path 被移除或替换
+	p.rwMu.Lock()
+	defer p.rwMu.Unlock()
+	fileInfo, ok := p.logMap[path]
+	if !ok {
+		return p.storeLogPath(new, true)
+	}
+	return fileInfo.attach(new[path])
+}
+
+// RemovePath 移除 path, 不再采集
+// 说明:
+//  1. path 为已添加的文件, 目录或 AddGlob 的 pattern 时, 整个移除
+//  2. path 为已添加目录下的文件时, 只移除该文件, 之后目录中该文件的变化也不会采集
+//  3. 会等正在进行的解析完成后, 输出合并中的内容, 保存最后的偏移量并关闭文件句柄; cleanOffset 为 true 时删除保存的偏移量
+func (p *PsLog) RemovePath(path string, cleanOffset ...bool) error {
+	defaultCleanOffset := false
+	if len(cleanOffset) > 0 {
+		defaultCleanOffset = cleanOffset[0]
+	}
+	path = filepath.Clean(path)
+
+	p.rwMu.Lock()
+	key := path
+	if _, ok := p.logMap[key]; !ok {
+		for k, v := range p.logMap {
+			if v.Handler.globPattern() == path {
+				key = k
+				break
+			}
+		}
+	}
+	if fileInfo, ok := p.logMap[key]; ok {
+		p.unregister(key)
+		p.rwMu.Unlock()
+		p.flushAll(fileInfo)
+		fileInfo.close(defaultCleanOffset)
+		plg.Infof("%q is removed", path)
+		return nil
+	}
+
+	// 目录下的文件
+	var dirInfo *FileInfo
+	for _, fileInfo := range p.logMap {
+		if fileInfo.IsDir() && fileInfo.inDir(path) {
+			dirInfo = fileInfo
+			break
+		}
+	}
+	p.rwMu.Unlock()
+	if dirInfo == nil {
+		return fmt.Errorf("%q is not exist", path)
+	}
+	if child := dirInfo.excludeChild(path); child != nil {
+		p.closeChild(child, defaultCleanOffset)
+	}
+	plg.Infof("%q is removed from %q", path, dirInfo.Dir)
+	return nil
+}
+
+// unregister 从 logMap 和 watch 中移除, 调用方需加锁
+func (p *PsLog) unregister(path string) {
+	delete(p.logMap, path)
+	if p.watch != nil { // 如果只是 cron 的话, 此处为 nil
+		if err := p.watch.Remove(path); err != nil {
+			plg.Errorf("p.watch.Remove %q is failed, err: %v", path, err)
+		}
+	}
+}
+
+// AddGlob 按 pattern 添加, 支持 filepath.Match 的语法, 以及 ** 匹配任意层级的目录, 如: /data/*/log/*.log
+// 说明:
+//  1. 会递归监听 pattern 中不含通配符的目录, 新建的匹配的目录和文件都会被采集
+//  2. handler 为 nil 时, 会按 p.handler 来处理, 注: 使用的是 handler 的副本; handler.NeedCollect 不为 nil 时, 需同时满足
+//  3. pattern 已存在时跳过; 不含通配符的目录已被其他 pattern 或 path 使用时, 返回错误
+func (p *PsLog) AddGlob(pattern string, handler *Handler) error {
+	return p.addGlob(pattern, handler)
+}
+
+// ReplaceGlob 新增 pattern 对应的处理方法, 如果 pattern 已存在则替换, 反之新增
+// 说明: 替换时保留已采集文件的偏移量和文件句柄, 附加的 handler 不会保留; 不含通配符的目录已被其他 pattern 或 path 使用时, 返回错误
+func (p *PsLog) ReplaceGlob(pattern string, handler *Handler) error {
+	return p.addGlob(pattern, handler, false)
+}
+
+func (p *PsLog) addGlob(pattern string, handler *Handler, existSkip ...bool) error {
+	glob, err := NewGlob(pattern)
+	if err != nil {
+		return err
+	}
+	if handler == nil {
+		handler = p.handler
+	}
+	if handler == nil {
+		return fmt.Errorf("%q no has handler", pattern)
+	}
+	handler = handler.copy()
+
+	needCollect := handler.NeedCollect
+	handler.NeedCollect = func(filename string) bool {
+		if !glob.Match(filename) {
+			return false
+		}
+		return needCollect == nil || needCollect(filename)
+	}
+	handler.Recursive = true
+	if handler.MaxDepth == 0 {
+		handler.MaxDepth = glob.MaxDepth()
+	}
+	handler.glob = glob
+	return p.addLogPath(map[string]*Handler{glob.Root(): handler}, existSkip...)
+}
+
 // addLogPath 添加 log path, 同时添加监听 log path
 func (p *PsLog) addLogPath(path2HandlerMap map[string]*Handler, existSkip ...bool) error {
 	defaultExistSkip := true // 默认新增, 存在跳过
@@ -150,15 +342,38 @@ func (p *PsLog) addLogPath(path2HandlerMap map[string]*Handler, existSkip ...boo
 	// 加锁处理
 	p.rwMu.Lock()
 	defer p.rwMu.Unlock()
+	return p.storeLogPath(new, defaultExistSkip)
+}
+
+// storeLogPath 保存预处理后的 log path, 同时添加监听 log path, 调用方需持有 p.rwMu
+func (p *PsLog) storeLogPath(new map[string]*Handler, existSkip bool) error {
+	var err error
 	for path, handler := range new {
 		fileInfo, ok := p.logMap[path]
-		if ok && defaultExistSkip {
+		if ok && fileInfo.Handler.globPattern() != handler.globPattern() {
+			// 不同的 pattern 或 pattern 和 path 对应同一个目录
+			added := fileInfo.Handler.globPattern()
+			if added == "" {
+				added = path
+			}
+			return fmt.Errorf("%q is already added by %q", path, added)
+		}
+		if ok && existSkip {
 			continue
 		}
 
 		// 保存 file
-		if ok {
-			fileInfo.Handler = handler
+		if ok && watchChanged(fileInfo.Handler, handler) {
+			fileInfo, err = p.reopen(path, fileInfo, handler)
+			if err != nil {
+				return err
+			}
+			p.reparse(fileInfo)
+		} else if ok {
+			// 替换时保留偏移量和文件句柄
+			if err := p.replaceHandler(fileInfo, handler); err != nil {
+				return err
+			}
 		} else {
 			fileInfo, err = NewFileInfo(path, handler)
 			if err != nil {
@@ -167,8 +382,8 @@ func (p *PsLog) addLogPath(path2HandlerMap map[string]*Handler, existSkip ...boo
 		}
 		if p.tail && handler.Tail {
 			// 直接监听对应的目录
-			if err := p.watch.Add(path); err != nil {
-				return fmt.Errorf("p.watch.Add is failed, err: %v", err)
+			if err := p.addWatch(path, handler); err != nil {
+				return err
 			}
 		}
 		p.logMap[path] = fileInfo
@@ -177,6 +392,119 @@ func (p *PsLog) addLogPath(path2HandlerMap map[string]*Handler, existSkip ...boo
 	return nil
 }
 
+// replaceHandler 替换 handler, 保留偏移量和文件句柄, 调用方需持有 p.rwMu
+// 说明:
+//  1. 旧 handler 解码, 合并中的内容会先输出, 防止丢失
+//  2. 目录下已采集的文件按新 handler 重新复制, 都成功后再替换, 不再需要采集的文件通过文件的任务队列保存偏移量并关闭
+//  3. 通过 AttachPath2Handler 附加的 handler 不会保留, 需要时替换后再附加
+func (p *PsLog) replaceHandler(fileInfo *FileInfo, handler *Handler) error {
+	fileInfo.mu.Lock()
+	defer fileInfo.mu.Unlock()
+	handler.pending = fileInfo.Handler.pending // 以当前的状态为准, 等 path 出现后再激活
+	if !fileInfo.IsDir() {
+		p.flushMerge(fileInfo)
+		fileInfo.Handler = handler
+		return nil
+	}
+
+	handlers := make(map[string]*Handler, len(fileInfo.children))
+	for name, child := range fileInfo.children {
+		filename := child.FileName()
+		if !handler.needCollect(filename) {
+			continue
+		}
+		tmp := handler.copyFor(filename)
+		tmp.path = filename
+		if err := tmp.init(); err != nil {
+			return err
+		}
+		handlers[name] = tmp
+	}
+
+	fileInfo.Handler = handler
+	for name, child := range fileInfo.children {
+		tmp, ok := handlers[name]
+		if !ok {
+			delete(fileInfo.children, name)
+			p.closeChild(child, false)
+			continue
+		}
+		child.mu.Lock()
+		p.flushMerge(child)
+		child.Handler = tmp
+		child.mu.Unlock()
+	}
+	return nil
+}
+
+// reopen 监听方式(Recursive, Poll, MaxDepth)有变化时, 移除后按新的 handler 重新添加, 调用方需持有 p.rwMu
+// 说明: 合并中的内容会先输出, 偏移量保存后重新加载, 继续采集
+func (p *PsLog) reopen(path string, fileInfo *FileInfo, handler *Handler) (*FileInfo, error) {
+	p.flushAll(fileInfo)
+	p.unregister(path)
+	fileInfo.close(false)
+	return NewFileInfo(path, handler)
+}
+
+// flushAll 输出文件或目录下文件合并中的内容, 用于移除前
+func (p *PsLog) flushAll(fileInfo *FileInfo) {
+	fileInfo.mu.Lock()
+	defer fileInfo.mu.Unlock()
+	if !fileInfo.IsDir() {
+		p.flushMerge(fileInfo)
+		return
+	}
+	for _, child := range fileInfo.children {
+		child.mu.Lock()
+		p.flushMerge(child)
+		child.mu.Unlock()
+	}
+}
+
+// reparse 重新添加后, 从保存的偏移量继续解析
+func (p *PsLog) reparse(fileInfo *FileInfo) {
+	if !p.tail || fileInfo.IsPending() {
+		return
+	}
+	if fileInfo.IsDir() {
+		p.parseChildren(fileInfo)
+		return
+	}
+	if fileInfo.Handler.Tail {
+		p.tailParse(fileInfo)
+	}
+}
+
+// watchChanged handler 的监听方式是否有变化
+func watchChanged(old, new *Handler) bool {
+	return old.Recursive != new.Recursive || old.Poll != new.Poll || old.MaxDepth != new.MaxDepth
+}
+
+// addWatch 监听 path, 递归采集的目录需要同时监听子目录
+func (p *PsLog) addWatch(path string, handler *Handler) error {
+	if handler.isDir && handler.Recursive {
+		if err := p.watch.AddRecursive(path, handler.MaxDepth); err != nil {
+			return fmt.Errorf("p.watch.AddRecursive is failed, err: %v", err)
+		}
+		return p.setPoll(path, handler)
+	}
+	if err := p.watch.Add(path); err != nil {
+		return fmt.Errorf("p.watch.Add is failed, err: %v", err)
+	}
+	return p.setPoll(path, handler)
+}
+
+// setPoll 需要轮询的 path
+func (p *PsLog) setPoll(path string, handler *Handler) error {
+	if !handler.Poll {
+		return nil
+	}
+	if err := p.watch.SetPoll(path); err != nil {
+		return fmt.Errorf("p.watch.SetPoll is failed, err: %v", err)
+	}
+	return nil
+}
+
 // prePath2Handler 预处理
 func (p *PsLog) prePath2Handler(path2HandlerMap map[string]*Handler) (map[string]*Handler, error) {
 	// 验证加处理
@@ -202,12 +530,15 @@ func (p *PsLog) prePath2Handler(path2HandlerMap map[string]*Handler) (map[string
 
 // Close 释放资源
 func (p *PsLog) Close() {
+	// 和 submitTail 互斥, 关闭后不会再提交任务
+	p.submitMu.Lock()
 	if p.HasClose() {
 		// 已经关了的就退出, 防止重复关闭 chan panic
+		p.submitMu.Unlock()
 		return
-	} else {
-		atomic.StoreInt32(&p.closed, 1)
 	}
+	atomic.StoreInt32(&p.closed, 1)
+	p.submitMu.Unlock()
 
 	if p.watch != nil {
 		p.watch.Close()
@@ -215,12 +546,27 @@ func (p *PsLog) Close() {
 	if p.taskPool != nil {
 		p.taskPool.SafeClose()
 	}
+	if p.writePool != nil {
+		p.writePool.SafeClose()
+	}
+
+	// 保存偏移量, 防止退出时丢失未达到 Change 阈值的偏移量
+	p.rwMu.RLock()
+	for _, fileInfo := range p.logMap {
+		fileInfo.flushOffset()
+	}
+	p.rwMu.RUnlock()
 	filePool.Close()
 
 	// close(p.watchCh) // p.watch.Close() 执行后, p.watchCh 会被关闭
 	close(p.closeCh)
 }
 
+// Done 关闭后返回的 chan 会被关闭, 用于等待 PsLog 退出
+func (p *PsLog) Done() <-chan struct{} {
+	return p.closeCh
+}
+
 // TailLogs 实时解析 log
 // watchSize 为监听到文件变化处理数据的 chan 的长度, 建议为监听文件的个数
 func (p *PsLog) TailLogs(watchChSize ...int) error {
@@ -232,7 +578,7 @@ func (p *PsLog) TailLogs(watchChSize ...int) error {
 	}
 
 	// 初始化 watch
-	watch, err := NewWatch()
+	watch, err := NewWatch(p.watchOpts...)
 	if err != nil {
 		return fmt.Errorf("NewWatch is failed, err:%v", err)
 	}
@@ -247,49 +593,324 @@ func (p *PsLog) TailLogs(watchChSize ...int) error {
 		// 退出情况
 		// 1. watch 退出
 		// 2. Close 后
-		for watchInfo := range p.watchCh {
-			p.rwMu.RLock()
-			fileInfo, ok := p.logMap[watchInfo.Path]
-			p.rwMu.RUnlock()
-			if !ok {
-				plg.Infof("%q is not exist", watchInfo.Path)
-				continue
+		for {
+			select {
+			case watchInfo, ok := <-p.watchCh:
+				if !ok {
+					plg.Info("watchCh is closed")
+					return
+				}
+				// 合并已经在 chan 中的事件, 同一个文件的多个 Write 只处理一次
+				for _, tmp := range p.drainWatch(watchInfo) {
+					p.handleWatch(tmp)
+				}
+			case <-p.watch.RescanCh():
+				p.watch.resync()
+				p.rescan()
 			}
+		}
+	}()
+	return nil
+}
 
-			// 如果是目录, 判断下是否需要采集
-			if fileInfo.IsDir() && !fileInfo.needCollect(watchInfo.ChangedFilename) {
-				plg.Infof("%q no need collect", watchInfo.ChangedFilename)
-				continue
+// rescan 全量扫描实时处理的 path, 用于事件丢失后补偿
+// 说明:
+//  1. 已出现的 pending path 开始采集, 已删除的 path 等重新创建后再采集
+//  2. 目录会补充新增的文件, 移除已删除的文件
+//  3. 文件变小时(如: 被清空)从头开始采集
+func (p *PsLog) rescan() {
+	plg.Info("rescan is running")
+	p.rwMu.RLock()
+	fileInfos := make([]*FileInfo, 0, len(p.logMap))
+	for _, fileInfo := range p.logMap {
+		if fileInfo.Handler.Tail {
+			fileInfos = append(fileInfos, fileInfo)
+		}
+	}
+	p.rwMu.RUnlock()
+
+	for _, fileInfo := range fileInfos {
+		if fileInfo.loadPending() && !p.activate(fileInfo) {
+			continue
+		}
+		path := fileInfo.Handler.path
+		if !fileInfo.IsDir() {
+			p.rescanFile(fileInfo, path)
+			continue
+		}
+
+		if !xf.Exists(path) {
+			p.deactivateDir(fileInfo)
+			continue
+		}
+		for _, child := range fileInfo.childList() {
+			if !xf.Exists(child.FileName()) {
+				p.removeChild(fileInfo, child.FileName(), true)
+			}
+		}
+		fileInfo.walkDir(path, func(filename string, info os.FileInfo) error {
+			if !fileInfo.needCollect(filename) {
+				return nil
 			}
+			child, err := fileInfo.getFileInfo(filename)
+			if err != nil {
+				plg.Errorf("getFileInfo %q is failed, err: %v", filename, err)
+				return nil
+			}
+			p.rescanFile(child, filename)
+			return nil
+		})
+	}
+}
 
-			if !fileInfo.Handler.Tail {
-				plg.Infof("%q no need tail", watchInfo.Path)
-				continue
+// rescanFile 全量扫描时处理单个文件
+func (p *PsLog) rescanFile(fileInfo *FileInfo, filename string) {
+	p.submitTail(fileInfo, func() {
+		st, err := os.Stat(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				fileInfo.deactivate()
 			}
+			return
+		}
+		if st.Size() < fileInfo.loadOffset() {
+			plg.Infof("%q is truncated, it will collect from begin", filename)
+			p.resetLog(fileInfo)
+		}
+		p.parseLog(false, fileInfo)
+	})
+}
 
-			// 目录的话, 需要取对应的信息
-			if fileInfo.IsDir() {
-				tmp, err := fileInfo.getFileInfo(watchInfo.ChangedFilename)
-				if err != nil {
-					plg.Errorf("getFileInfo %q is failed, err: %v", watchInfo.ChangedFilename, err)
-					continue
-				}
-				fileInfo = tmp
+// drainWatch 取出 watchCh 中已有的事件, 同一个文件连续的 Write 只保留第一个
+// 说明: 文件的 Write 之间有其他事件(如: Rename, Create)时不合并, 保证处理顺序
+func (p *PsLog) drainWatch(first *WatchFileInfo) []*WatchFileInfo {
+	size := len(p.watchCh)
+	res := make([]*WatchFileInfo, 0, size+1)
+	writeMap := make(map[string]bool, size+1) // key: ChangedFilename, 是否已有 Write
+	add := func(watchInfo *WatchFileInfo) {
+		if watchInfo.Op != fs.Write {
+			delete(writeMap, watchInfo.ChangedFilename)
+			res = append(res, watchInfo)
+			return
+		}
+		if writeMap[watchInfo.ChangedFilename] {
+			return
+		}
+		writeMap[watchInfo.ChangedFilename] = true
+		res = append(res, watchInfo)
+	}
+
+	add(first)
+	for i := 0; i < size; i++ {
+		select {
+		case watchInfo, ok := <-p.watchCh:
+			if !ok {
+				return res
 			}
-			fileInfo.op = watchInfo.Op
-			fileInfo.watchChangeFilename = watchInfo.ChangedFilename
+			add(watchInfo)
+		default:
+			return res
+		}
+	}
+	return res
+}
 
-			// 是否修改名称, 需要重置下
-			if isRename(fileInfo.op) {
-				plg.Infof("rename %q, it will reset", fileInfo.FileName())
-				fileInfo.resetFn()
-				continue
+// handleWatch 处理监听到的文件变化
+func (p *PsLog) handleWatch(watchInfo *WatchFileInfo) {
+	p.rwMu.RLock()
+	fileInfo, ok := p.logMap[watchInfo.Path]
+	p.rwMu.RUnlock()
+	if !ok {
+		plg.Infof("%q is not exist", watchInfo.Path)
+		return
+	}
+
+	// 注册时还不存在的 path, 出现后开始采集
+	activated := fileInfo.loadPending()
+	if activated {
+		if !p.activate(fileInfo) {
+			return
+		}
+		if fileInfo.IsDir() {
+			p.parseChildren(fileInfo)
+			return
+		}
+	}
+
+	// 监听的 path 被删除, 等重新创建后再采集
+	if isRemove(watchInfo.Op) && watchInfo.ChangedFilename == watchInfo.Path {
+		plg.Infof("%q is removed, it will collect when created", watchInfo.Path)
+		if fileInfo.IsDir() {
+			p.deactivateDir(fileInfo)
+		} else {
+			p.submitTail(fileInfo, fileInfo.deactivate)
+		}
+		return
+	}
+
+	// 目录下的文件或子目录被删除/重命名, 关闭句柄, 文件再出现时再采集
+	if fileInfo.IsDir() && (isRemove(watchInfo.Op) || isRename(watchInfo.Op)) {
+		plg.Infof("%q is removed or renamed", watchInfo.ChangedFilename)
+		p.removeChild(fileInfo, watchInfo.ChangedFilename, isRemove(watchInfo.Op))
+		return
+	}
+
+	// 目录下新建的子目录, 递归采集时需要采集子目录下已有的文件
+	if fileInfo.IsDir() && isCreate(watchInfo.Op) && isDirPath(watchInfo.ChangedFilename) {
+		if fileInfo.Handler.Recursive {
+			p.parseSubDir(fileInfo, watchInfo.ChangedFilename)
+		}
+		return
+	}
+
+	// 如果是目录, 判断下是否需要采集
+	if fileInfo.IsDir() && !fileInfo.needCollect(watchInfo.ChangedFilename) {
+		plg.Infof("%q no need collect", watchInfo.ChangedFilename)
+		return
+	}
+
+	if !fileInfo.Handler.Tail {
+		plg.Infof("%q no need tail", watchInfo.Path)
+		return
+	}
+
+	// 目录的话, 需要取对应的信息
+	resume := false // 重命名后又移回的文件, 继续采集
+	if fileInfo.IsDir() {
+		resume = isCreate(watchInfo.Op) && !fileInfo.hasChild(watchInfo.ChangedFilename)
+		tmp, err := fileInfo.getFileInfo(watchInfo.ChangedFilename)
+		if err != nil {
+			plg.Errorf("getFileInfo %q is failed, err: %v", watchInfo.ChangedFilename, err)
+			return
+		}
+		fileInfo = tmp
+	}
+
+	// 是否修改名称, 需要重置下
+	if isRename(watchInfo.Op) {
+		p.submitTail(fileInfo, func() {
+			plg.Infof("rename %q, it will reset", fileInfo.FileName())
+			p.resetLog(fileInfo)
+		})
+		return
+	}
+
+	// 新建的文件, 从头开始采集; 目录下重命名后又移回的文件, 保存的偏移量有效时继续采集
+	if isCreate(watchInfo.Op) && !activated {
+		p.submitTail(fileInfo, func() {
+			if !resume {
+				plg.Infof("create %q, it will collect from begin", fileInfo.FileName())
+				p.resetLog(fileInfo)
 			}
-			p.parseLog(false, fileInfo)
+			p.parseLog(false, fileInfo, watchInfo)
+		})
+		return
+	}
+	p.tailParse(fileInfo, watchInfo)
+}
+
+// removeChild 目录下的文件或子目录被删除/重命名后, 从目录中移除, 通过文件的任务队列关闭, 保证已提交的任务先处理
+// 说明: 被删除时清理偏移量; 被重命名时保留偏移量, 文件移回后继续采集
+func (p *PsLog) removeChild(dirInfo *FileInfo, filename string, cleanOffset bool) {
+	for _, child := range dirInfo.detachChild(filename) {
+		p.closeChild(child, cleanOffset)
+	}
+}
+
+// closeChild 通过文件的任务队列输出合并中的内容后关闭, 保证已提交的任务先处理
+func (p *PsLog) closeChild(child *FileInfo, cleanOffset bool) {
+	submitted := p.submitTail(child, func() {
+		child.mu.Lock()
+		p.flushMerge(child)
+		child.mu.Unlock()
+		child.close(cleanOffset)
+	})
+	if !submitted { // 已关闭
+		child.close(cleanOffset)
+	}
+}
+
+// deactivateDir 目录被删除后, 移除目录下所有的文件, 等重新创建后再采集
+func (p *PsLog) deactivateDir(dirInfo *FileInfo) {
+	p.removeChild(dirInfo, dirInfo.Dir, true)
+	dirInfo.deactivate()
+}
+
+// tailParse 实时解析文件
+// 说明: 每个文件最多只有一个待处理的解析, 已有时合并; 设置了 WithTailInterval 时, 两次解析的间隔不小于该值
+func (p *PsLog) tailParse(fileInfo *FileInfo, watchInfo ...*WatchFileInfo) {
+	if !atomic.CompareAndSwapInt32(&fileInfo.tailPending, 0, 1) {
+		return
+	}
+
+	parse := func() {
+		// 先清理标记, 解析过程中的变化需要再次解析
+		atomic.StoreInt32(&fileInfo.tailPending, 0)
+		atomic.StoreInt64(&fileInfo.lastTail, time.Now().UnixNano())
+		p.parseLog(false, fileInfo, watchInfo...)
+	}
+	delay := p.tailInterval - time.Since(time.Unix(0, atomic.LoadInt64(&fileInfo.lastTail)))
+	if delay <= 0 {
+		p.submitTail(fileInfo, parse)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		p.submitTail(fileInfo, parse)
+	})
+}
+
+// submitTail 提交实时处理文件的任务到 taskPool, 返回是否已提交, 已关闭时不提交
+// 说明:
+//  1. 同一个文件的任务按提交顺序串行处理, 不同文件的任务并行处理
+//  2. 判断是否关闭和提交在 submitMu 内, 防止 Close 后再提交; 注: taskPool 中的任务不能调用
+func (p *PsLog) submitTail(fileInfo *FileInfo, task func()) bool {
+	p.submitMu.RLock()
+	defer p.submitMu.RUnlock()
+	if p.HasClose() {
+		return false
+	}
+
+	fileInfo.taskMu.Lock()
+	fileInfo.tasks = append(fileInfo.tasks, task)
+	if fileInfo.taskRunning {
+		fileInfo.taskMu.Unlock()
+		return true
+	}
+	fileInfo.taskRunning = true
+	fileInfo.taskMu.Unlock()
+
+	p.taskPool.Submit(func() {
+		p.runTail(fileInfo)
+	})
+	return true
+}
+
+// runTail 依次处理文件的任务, 处理完后退出
+func (p *PsLog) runTail(fileInfo *FileInfo) {
+	for {
+		fileInfo.taskMu.Lock()
+		if len(fileInfo.tasks) == 0 {
+			fileInfo.taskRunning = false
+			fileInfo.taskMu.Unlock()
+			return
+		}
+		task := fileInfo.tasks[0]
+		fileInfo.tasks[0] = nil
+		fileInfo.tasks = fileInfo.tasks[1:]
+		fileInfo.taskMu.Unlock()
+
+		p.runTailTask(fileInfo, task)
+	}
+}
+
+// runTailTask 处理单个任务, 防止 panic 后文件的任务不再处理
+func (p *PsLog) runTailTask(fileInfo *FileInfo, task func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			plg.Errorf("tail %q recover err: %v, stack: %s", fileInfo.FileName(), err, debug.Stack())
 		}
-		plg.Info("watchCh is closed")
 	}()
-	return nil
+	task()
 }
 
 // cronLog 定时解析 log
@@ -303,14 +924,16 @@ func (p *PsLog) CronLogs(makeUpTail ...bool) {
 	p.rwMu.RLock()
 	tmpLogMap := make(map[string]*FileInfo, len(p.logMap))
 	for path, fileInfo := range p.logMap {
+		if fileInfo.loadPending() && !p.activate(fileInfo) {
+			continue
+		}
 		if !fileInfo.IsDir() {
 			tmpLogMap[path] = fileInfo
 			continue
 		}
 
 		// 目录
-		fileInfo.loopDir(path, func(info os.FileInfo) error {
-			filename := filepath.Join(path, info.Name())
+		fileInfo.walkDir(path, func(filename string, info os.FileInfo) error {
 			if fileInfo.needCollect(filename) {
 				tmp, err := fileInfo.getFileInfo(filename)
 				if err != nil {
@@ -340,8 +963,53 @@ func (p *PsLog) CronLogs(makeUpTail ...bool) {
 	}
 }
 
-// parseLog 解析文件
-func (p *PsLog) parseLog(mustSaveOffset bool, fileInfo *FileInfo) {
+// activate 初始化已出现的 pending path, 返回是否可以开始采集
+func (p *PsLog) activate(fileInfo *FileInfo) bool {
+	ok, err := fileInfo.activate()
+	if err != nil {
+		plg.Errorf("fileInfo.activate %q is failed, err: %v", fileInfo.Handler.path, err)
+		return false
+	}
+	if ok {
+		plg.Infof("%q is created, it will collect", fileInfo.Handler.path)
+	}
+	return ok
+}
+
+// parseChildren 解析目录下需要采集的文件
+func (p *PsLog) parseChildren(fileInfo *FileInfo) {
+	if !fileInfo.Handler.Tail {
+		return
+	}
+	for _, child := range fileInfo.childList() {
+		p.tailParse(child)
+	}
+}
+
+// parseSubDir 解析递归采集的目录下新建的子目录中已有的文件
+func (p *PsLog) parseSubDir(fileInfo *FileInfo, dir string) {
+	if !fileInfo.Handler.Tail {
+		return
+	}
+	if filepath.Base(dir) == saveOffsetDir || !dirInDepth(fileInfo.Handler.path, dir, fileInfo.Handler.MaxDepth) {
+		return
+	}
+	fileInfo.walkDir(dir, func(filename string, info os.FileInfo) error {
+		if !fileInfo.needCollect(filename) {
+			return nil
+		}
+		child, err := fileInfo.getFileInfo(filename)
+		if err != nil {
+			plg.Errorf("getFileInfo %q is failed, err: %v", filename, err)
+			return nil
+		}
+		p.tailParse(child)
+		return nil
+	})
+}
+
+// parseLog 解析文件, watchInfo 为触发解析的事件, 在锁内记录到 fileInfo
+func (p *PsLog) parseLog(mustSaveOffset bool, fileInfo *FileInfo, watchInfo ...*WatchFileInfo) {
 	if p.HasClose() {
 		plg.Warning("ps-log is closed")
 		return
@@ -349,6 +1017,13 @@ func (p *PsLog) parseLog(mustSaveOffset bool, fileInfo *FileInfo) {
 	// 防止 tail 和 cron 对同一个文件进行操作
 	fileInfo.mu.Lock()
 	defer fileInfo.mu.Unlock()
+	if fileInfo.removed {
+		return
+	}
+	if len(watchInfo) > 0 {
+		fileInfo.op = watchInfo[0].Op
+		fileInfo.watchChangeFilename = watchInfo[0].ChangedFilename
+	}
 
 	f, err := fileInfo.getFileHandle()
 	if err != nil {
@@ -368,20 +1043,27 @@ func (p *PsLog) parseLog(mustSaveOffset bool, fileInfo *FileInfo) {
 		plg.Infof("offset: %d, fileSize: %d it will skip", fileInfo.offset, fileSize)
 		return
 	}
+	fileInfo.active()
 	handler := fileInfo.Handler
 	// 这里单个文件, 循环采集
 	if fileInfo.offset > fileSize && handler.LoopParse {
 		fileInfo.offset = 0
 	}
 
-	dataMap := make(map[int]*LogHandlerBus, 1<<3) // key: target.no, 支持一个匹配规则多个处理方式
+	// 文件只读取一次, 每行分发给各个 handler
+	handlers := handler.handlers()
+	dataMaps := make([]map[int]*LogHandlerBus, len(handlers))
+	for i := range handlers {
+		dataMaps[i] = make(map[int]*LogHandlerBus, 1<<3) // key: target.no, 支持一个匹配规则多个处理方式
+	}
+	rowOffset := fileInfo.offset
 	offset, err := fileInfo.ScanLinesOfInCr(func(row []byte) error {
 		// 处理行内容, 解决日志中可能出现的换行, 如: err stack
 		// fmt.Println("===:", string(rowBytes))
-		if !handler.MergeRule.Append(row) {
-			return nil
+		for i, h := range handlers {
+			p.handleRow(fileInfo, h, dataMaps[i], row, rowOffset)
 		}
-		p.handleLine(fileInfo, dataMap, handler.MergeRule.Line())
+		rowOffset += int64(len(row))
 		return nil
 	})
 	if err != nil {
@@ -389,28 +1071,136 @@ func (p *PsLog) parseLog(mustSaveOffset bool, fileInfo *FileInfo) {
 		return
 	}
 
-	// 说明还有内容没有读取完
-	if !handler.MergeRule.Null() {
-		// plg.Infof("fileSize: %d, readSize: %d, residue: %d, total: %d", fileSize, readSize, residue, readSize+int64(residue))
-		p.handleLine(fileInfo, dataMap, handler.MergeRule.Line())
-	}
+	// 解码, 合并中还未完成的内容保留到下次解析, 如: err stack 分多次写入
+	commitOffset := offset
+	for i, h := range handlers {
+		if h.hasPending() && h.mergeOffset < commitOffset {
+			commitOffset = h.mergeOffset
+		}
 
-	// plg.Info("dataMap:", base.ToString(dataMap))
-	if len(dataMap) > 0 {
-		p.writer(dataMap)
+		// plg.Info("dataMap:", base.ToString(dataMap))
+		if len(dataMaps[i]) > 0 {
+			p.writer(dataMaps[i])
+		}
 	}
 
 	// 保存偏移量
 	fileInfo.storeOffset(offset)
-	p.taskPool.Submit(func() {
-		fileInfo.saveOffset(mustSaveOffset)
+	atomic.StoreInt64(&fileInfo.mergeSize, offset-commitOffset)
+	if commitOffset < offset {
+		p.delayFlushMerge(fileInfo)
+	}
+	// 已在 taskPool 中, 直接保存, 防止协程池满时再提交任务阻塞
+	fileInfo.saveOffset(mustSaveOffset)
+}
+
+// handleRow 处理原始行, 解码, 合并后再处理
+// rowOffset 为行开始的偏移量, 用于记录解码, 合并中的事件开始的位置
+func (p *PsLog) handleRow(fileInfo *FileInfo, h *Handler, dataMap map[int]*LogHandlerBus, row []byte, rowOffset int64) {
+	if !h.decoding() {
+		h.recordOffset = rowOffset
+		if h.MergeRule.Pending() == 0 {
+			h.mergeOffset = rowOffset
+		}
+	}
+
+	record := &line.Record{Line: row}
+	if h.Decoder != nil {
+		var ok bool
+		if record, ok = h.Decoder.Decode(row); !ok {
+			return
+		}
+	}
+	p.appendRecord(fileInfo, h, dataMap, record)
+}
+
+// appendRecord 合并解码后的记录, 合并完成的事件以第一条记录的字段为准
+func (p *PsLog) appendRecord(fileInfo *FileInfo, h *Handler, dataMap map[int]*LogHandlerBus, record *line.Record) {
+	if h.MergeRule.Pending() == 0 {
+		h.fields = record.Fields
+		h.records = h.records[:0]
+	}
+	h.records = append(h.records, recordPos{offset: h.recordOffset, size: len(record.Line), fields: record.Fields})
+	if !h.MergeRule.Append(record.Line) {
+		return
+	}
+
+	fields := h.fields
+	h.syncMergeOffset() // 剩余的记录为新事件的开始
+	p.handleLine(fileInfo, h, dataMap, h.MergeRule.Line(), fields)
+	for line.More(h.MergeRule) {
+		p.handleLine(fileInfo, h, dataMap, h.MergeRule.Line(), fields)
+	}
+}
+
+// flushMerge 输出解码, 合并中的内容, 调用方需加锁
+func (p *PsLog) flushMerge(fileInfo *FileInfo) {
+	for _, h := range fileInfo.Handler.handlers() {
+		dataMap := make(map[int]*LogHandlerBus, 1)
+		if h.Decoder != nil {
+			if record, ok := h.Decoder.Flush(); ok {
+				p.appendRecord(fileInfo, h, dataMap, record)
+			}
+		}
+		if !h.MergeRule.Null() {
+			p.handleLine(fileInfo, h, dataMap, h.MergeRule.Line(), h.fields)
+			for line.More(h.MergeRule) {
+				p.handleLine(fileInfo, h, dataMap, h.MergeRule.Line(), h.fields)
+			}
+		}
+		h.fields = nil
+		h.records = h.records[:0]
+		if len(dataMap) > 0 {
+			p.writer(dataMap)
+		}
+	}
+	atomic.StoreInt64(&fileInfo.mergeSize, 0)
+}
+
+// delayFlushMerge 合并中的内容在 MergeTimeout 内没有新的行时输出, 调用方需加锁
+func (p *PsLog) delayFlushMerge(fileInfo *FileInfo) {
+	timeout := fileInfo.Handler.MergeTimeout
+	if timeout < 0 {
+		return
+	}
+	if fileInfo.mergeTimer != nil {
+		fileInfo.mergeTimer.Reset(timeout)
+		return
+	}
+	fileInfo.mergeTimer = time.AfterFunc(timeout, func() {
+		p.submitTail(fileInfo, func() {
+			p.timeoutFlushMerge(fileInfo)
+		})
 	})
 }
 
+// timeoutFlushMerge 超时输出合并中的内容
+func (p *PsLog) timeoutFlushMerge(fileInfo *FileInfo) {
+	fileInfo.mu.Lock()
+	defer fileInfo.mu.Unlock()
+	if p.HasClose() || fileInfo.removed || atomic.LoadInt64(&fileInfo.mergeSize) == 0 {
+		return
+	}
+	// 定时器触发后又有新的内容, 等下一次
+	if time.Since(time.Unix(0, atomic.LoadInt64(&fileInfo.activeAt))) < fileInfo.Handler.MergeTimeout {
+		return
+	}
+	plg.Infof("%q merge is timeout, it will flush", fileInfo.FileName())
+	p.flushMerge(fileInfo)
+	fileInfo.saveOffset(false)
+}
+
+// resetLog 从头开始采集, 重置前先输出合并中的内容
+func (p *PsLog) resetLog(fileInfo *FileInfo) {
+	fileInfo.mu.Lock()
+	defer fileInfo.mu.Unlock()
+	p.flushMerge(fileInfo)
+	fileInfo.resetFn()
+}
+
 // handleLine 处理 line 内容
-func (p *PsLog) handleLine(fileInfo *FileInfo, dataMap map[int]*LogHandlerBus, line []byte) {
+func (p *PsLog) handleLine(fileInfo *FileInfo, handler *Handler, dataMap map[int]*LogHandlerBus, line []byte, fields map[string]string) {
 	// 判断下是否需要过滤掉
-	handler := fileInfo.Handler
 	if handler == nil {
 		return
 	}
@@ -427,13 +1217,21 @@ func (p *PsLog) handleLine(fileInfo *FileInfo, dataMap map[int]*LogHandlerBus, l
 	}
 
 	// plg.Info("target:", base.ToString(target))
+	// 有解码出的字段时, 每条记录单独输出
+	if len(fields) > 0 {
+		bus := &LogHandlerBus{LogPath: fileInfo.FileName(), Ext: handler.Ext, TargetExt: target.Ext, Fields: fields, Labels: handler.Labels, buf: new(bytes.Buffer), tos: target.To}
+		bus.Write(line)
+		p.writer(map[int]*LogHandlerBus{target.no: bus})
+		return
+	}
+
 	// 按不同内容进行处理
-	if handler, ok := dataMap[target.no]; !ok {
-		bus := &LogHandlerBus{LogPath: fileInfo.FileName(), Ext: fileInfo.Handler.Ext, TargetExt: target.Ext, buf: new(bytes.Buffer), tos: target.To}
+	if bus, ok := dataMap[target.no]; !ok {
+		bus = &LogHandlerBus{LogPath: fileInfo.FileName(), Ext: handler.Ext, TargetExt: target.Ext, Labels: handler.Labels, buf: new(bytes.Buffer), tos: target.To}
 		bus.Write(line)
 		dataMap[target.no] = bus
 	} else {
-		handler.Write(line)
+		bus.Write(line)
 	}
 }
 
@@ -450,9 +1248,9 @@ func (p *PsLog) writer(dataMap map[int]*LogHandlerBus) {
 		}
 		// plg.Info("writeTo msg:", bus.Msg)
 		for _, to := range bus.tos {
-			if p.async2Tos { // 异步
+			if p.async2Tos { // 异步, 使用单独的协程池, 防止解析任务中再提交到 taskPool 阻塞
 				tmpTo, tmpBus := to, bus
-				p.taskPool.Submit(func() {
+				p.writePool.Submit(func() {
 					tmpTo.WriteTo(tmpBus)
 				})
 				continue
@@ -496,22 +1294,34 @@ func (p *PsLog) cleanUp(t time.Time) {
 	// 处理过期的 path
 	p.rwMu.RLock()
 	deleteKeys := make([]string, 0, len(p.logMap))
+	idles := make([]*FileInfo, 0)
 	for path, fileInfo := range p.logMap {
+		if fileInfo.Handler.IdleExpire {
+			idles = append(idles, fileInfo)
+			continue
+		}
 		if fileInfo.IsExpire(t) {
 			deleteKeys = append(deleteKeys, path)
 		}
 	}
 	p.rwMu.RUnlock()
 
+	// 空闲过期的只关闭句柄, 不移除
+	for _, fileInfo := range idles {
+		if closed := fileInfo.idleClose(t); len(closed) > 0 {
+			plg.Info("cleanUp idle: ", strings.Join(closed, ",\n"))
+		}
+	}
+
 	defer plg.Info("cleanUp path: ", strings.Join(deleteKeys, ",\n"))
 	if len(deleteKeys) == 0 {
 		return
 	}
 
 	p.rwMu.Lock()
-	defer p.rwMu.Unlock()
 	// 1. 移除监听的 path,
-	// 2. 打开的文件句柄由 filePool 会根据 lru 淘汰时进行关闭, 不需在此处理, 但建议主动处理
+	// 2. 保存偏移量, 关闭文件句柄
+	removed := make([]*FileInfo, 0, len(deleteKeys))
 	for _, path := range deleteKeys {
 		fileInfo := p.logMap[path]
 		if fileInfo.IsDir() {
@@ -519,12 +1329,13 @@ func (p *PsLog) cleanUp(t time.Time) {
 			fileInfo.Extension() // 延期
 			continue
 		}
-		delete(p.logMap, path)
-		filePool.Remove(path)
+		p.unregister(path)
+		removed = append(removed, fileInfo)
+	}
+	p.rwMu.Unlock()
 
-		if p.watch != nil { // 如果只是 cron 的话, 此处为 nil
-			p.watch.Remove(path)
-		}
+	for _, fileInfo := range removed {
+		fileInfo.close(false)
 	}
 }
 
@@ -561,10 +1372,17 @@ func (p *PsLog) List(printTarget ...bool) string {
 		if !p.firstCallList && v.Handler.Tail && v.loadBeginOffset() == v.loadOffset() {
 			tailStr += "(may cron)" // 可能出现在定时监听里
 		}
+		path := k
+		if v.Handler.glob != nil {
+			path = v.Handler.glob.Pattern()
+		}
+		if v.loadPending() {
+			path += "(pending)"
+		}
 		data := []string{
-			k,
+			path,
 			fmt.Sprint(filePool.GetFile2Open(v.FileName(), os.O_RDONLY)), // 记录当前文件打开的文件句柄
-			v.Handler.ExpireAt.Format(base.DatetimeFmt),
+			v.ExpireAt().Format(base.DatetimeFmt),
 			tailStr,
 			base.ToString(v.loadBeginOffset()),
 			base.ToString(v.loadOffset()),
@@ -8,12 +8,17 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"gitee.com/xuesongtao/gotool/base"
 	"gitee.com/xuesongtao/gotool/xfile"
+	"gitee.com/xuesongtao/ps-log/line"
 	plg "gitee.com/xuesongtao/ps-log/log"
 )
 
@@ -21,22 +26,113 @@ var (
 	tmpDir = "./tmp"
 )
 
+// waitFor 等待 cond 成立, 超时后失败
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait is timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// parsed filename 是否已解析到文件末尾, filename 可以为已添加目录下的文件
+func parsed(ps *PsLog, filename string) bool {
+	st, err := os.Stat(filename)
+	if err != nil {
+		return false
+	}
+	// 激活 pending 时会在锁内初始化 offset
+	done := func(f *FileInfo) bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.offset == st.Size()
+	}
+	ps.rwMu.RLock()
+	defer ps.rwMu.RUnlock()
+	for path, fileInfo := range ps.logMap {
+		if path == filename {
+			return done(fileInfo)
+		}
+		for _, child := range fileInfo.childList() {
+			if filepath.Join(child.Dir, child.Name) == filename {
+				return done(child)
+			}
+		}
+	}
+	return false
+}
+
+// watching dir 是否已被 w 监听(包括待创建的 path 和递归的子目录)
+func watching(w *Watch, dir string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if _, ok := w.subDirMap[dir]; ok {
+		return true
+	}
+	for _, infos := range []map[string]*WatchFileInfo{w.fileMap, w.pendingMap} {
+		for _, info := range infos {
+			if info.Dir == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inWatchList dir 是否在 inotify 的监听中
+func inWatchList(w *Watch, dir string) bool {
+	for _, v := range w.watcher.WatchList() {
+		if v == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// StrBuf 会被并发调用 WriteTo, 读取需要通过 String
 type StrBuf struct {
+	mu  sync.Mutex
 	Buf strings.Builder
 }
 
 func (s *StrBuf) WriteTo(bus *LogHandlerBus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.Buf.WriteString(bus.Msg)
 }
 
+func (s *StrBuf) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Buf.String()
+}
+
+func (s *StrBuf) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Buf.Reset()
+}
+
 type BytesBuf struct {
+	mu  sync.Mutex
 	Buf bytes.Buffer
 }
 
 func (b *BytesBuf) WriteTo(bus *LogHandlerBus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	b.Buf.WriteString(bus.Msg)
 }
 
+func (b *BytesBuf) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Buf.String()
+}
+
 func TestScan(t *testing.T) {
 	// f, err := os.Open("/Users/xuesongtao/Downloads/info.log")
 	f, err := os.Open("/Users/xuesongtao/Downloads/test.txt")
@@ -186,13 +282,13 @@ func TestTail(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	// fmt.Println(byteBuf.Buf.String())
-	// fmt.Println(strBuf.Buf.String())
+	// fmt.Println(byteBuf.String())
+	// fmt.Println(strBuf.String())
 	// fmt.Println(data)
-	if strings.TrimSpace(byteBuf.Buf.String()) != strings.TrimSpace(strBuf.Buf.String()) || strings.TrimSpace(byteBuf.Buf.String()) != data {
+	if strings.TrimSpace(byteBuf.String()) != strings.TrimSpace(strBuf.String()) || strings.TrimSpace(byteBuf.String()) != data {
 		t.Error("data:", data)
-		t.Error("byteBuf:", byteBuf.Buf.String())
-		t.Error("strBuf:", strBuf.Buf.String())
+		t.Error("byteBuf:", byteBuf.String())
+		t.Error("strBuf:", strBuf.String())
 	}
 }
 
@@ -329,10 +425,10 @@ func TestTailLoop(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if byteBuf.Buf.String() != strBuf.Buf.String() && byteBuf.Buf.String() != data {
+	if byteBuf.String() != strBuf.String() && byteBuf.String() != data {
 		t.Error("data:", data)
-		t.Error("byteBuf:", byteBuf.Buf.String())
-		t.Error("strBuf:", strBuf.Buf.String())
+		t.Error("byteBuf:", byteBuf.String())
+		t.Error("strBuf:", strBuf.String())
 	}
 }
 
@@ -402,9 +498,1159 @@ stopFor:
 		t.Fatal(err)
 	}
 
-	if byteBuf.Buf.String() != strBuf.Buf.String() && byteBuf.Buf.String() != data {
+	if byteBuf.String() != strBuf.String() && byteBuf.String() != data {
 		t.Error("data:", data)
-		t.Error("byteBuf:", byteBuf.Buf.String())
-		t.Error("strBuf:", strBuf.Buf.String())
+		t.Error("byteBuf:", byteBuf.String())
+		t.Error("strBuf:", strBuf.String())
+	}
+}
+
+func TestTailPending(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	tmp := filepath.Join(t.TempDir(), "log", "app.log")
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+	}
+	// 注册时目录和文件都还不存在
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+	t.Log(ps.List())
+
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return watching(ps.watch, filepath.Dir(tmp)) })
+	if _, err := xfile.AppendContent(tmp, "warning 1\nwarning 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, tmp) })
+
+	if got := strBuf.String(); strings.Count(got, "warning") != 2 {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestTailRemoveOpened(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	tmp := filepath.Join(t.TempDir(), "app.log")
+	if _, err := xfile.AppendContent(tmp, "warning 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+	}
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(tmp, "warning 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return strings.Contains(strBuf.String(), "warning 2") })
+
+	// 句柄未关闭时删除, 通过所在目录收到 Remove
+	fileInfo := ps.logMap[tmp]
+	if err := os.Remove(tmp); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		fileInfo.mu.Lock()
+		defer fileInfo.mu.Unlock()
+		return fileInfo.IsPending()
+	})
+
+	// 重新创建后从头采集
+	if _, err := xfile.AppendContent(tmp, "warning 3\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return strings.Contains(strBuf.String(), "warning 3") })
+	if got := strBuf.String(); strings.Count(got, "warning") != 3 {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestTailPendingOffset(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	dir := t.TempDir()
+	offsetDir := filepath.Join(dir, ".pslog", "offset")
+	if err := os.MkdirAll(offsetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a.log 保存的偏移量有效, 继续采集; b.log 保存的偏移量超过文件大小, 从头采集
+	content := "warning 1\nwarning 2\n"
+	offsets := map[string]int{"a.log": len("warning 1\n"), "b.log": 100}
+	for name, offset := range offsets {
+		if err := os.WriteFile(filepath.Join(offsetDir, "_"+name+".txt"), []byte(strconv.Itoa(offset)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		handler := &Handler{
+			Change:   -1,
+			Tail:     true,
+			ExpireAt: NoExpire,
+			Targets: []*Target{
+				{
+					Content: "warning",
+					To:      []PsLogWriter{strBuf},
+				},
+			},
+		}
+		if err := ps.AddPath2Handler(filepath.Join(dir, name), handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 整体移入, 保证出现时内容已完整
+	src := t.TempDir()
+	for name := range offsets {
+		tmp := filepath.Join(src, name)
+		if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		return parsed(ps, filepath.Join(dir, "a.log")) && parsed(ps, filepath.Join(dir, "b.log"))
+	})
+
+	if got := strBuf.String(); strings.Count(got, "warning 1") != 1 || strings.Count(got, "warning 2") != 2 {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestTailDirCreateRemove(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	dir := t.TempDir()
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+		NeedCollect: func(filename string) bool { return strings.HasSuffix(filename, ".log") },
+	}
+	if err := ps.AddDir2Handle(dir, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := filepath.Join(dir, "app.log")
+	if _, err := xfile.AppendContent(tmp, "warning 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, tmp) })
+
+	fileInfo := ps.logMap[dir]
+	if len(fileInfo.childList()) != 1 {
+		t.Fatal("child is not registered")
+	}
+	offsetFile := fileInfo.childList()[0].offsetFilename()
+	if !xfile.Exists(offsetFile) {
+		t.Fatal("offset is not saved")
+	}
+
+	if err := os.Remove(tmp); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return len(fileInfo.childList()) == 0 })
+	waitFor(t, 2*time.Second, func() bool { return !xfile.Exists(offsetFile) })
+	if got := strBuf.String(); strings.Count(got, "warning") != 1 {
+		t.Errorf("got: %q", got)
+	}
+
+	// 重命名时保留偏移量, 移回后继续采集
+	if _, err := xfile.AppendContent(tmp, "warning 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, tmp) })
+	bak := tmp + ".bak"
+	if err := os.Rename(tmp, bak); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return len(fileInfo.childList()) == 0 })
+	if !xfile.Exists(offsetFile) {
+		t.Error("offset should keep")
+	}
+	if _, err := xfile.AppendContent(bak, "warning 3\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(bak, tmp); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, tmp) })
+	if got := strBuf.String(); strings.Count(got, "warning 2") != 1 || strings.Count(got, "warning 3") != 1 {
+		t.Errorf("got: %q", got)
+	}
+
+	// 重命名后出现同名的其他文件(如: 轮转), 即使保存的偏移量不超过文件大小也从头采集
+	if err := os.Rename(tmp, bak); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		data, _ := os.ReadFile(offsetFile)
+		return len(fileInfo.childList()) == 0 && string(data) == "20"
+	})
+	src := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(src, []byte("warning 4\nwarning 5\nwarning 6\nwarning 7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(src, tmp); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return strings.Contains(strBuf.String(), "warning 7") })
+	if got := strBuf.String(); strings.Count(got, "warning 4") != 1 {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestTailDirRecursive(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	dir := t.TempDir()
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+		NeedCollect: func(filename string) bool { return strings.HasSuffix(filename, "app.log") },
+		Recursive:   true,
+		MaxDepth:    3,
+	}
+	if err := ps.AddDir2Handle(dir, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新建的子目录, 超过 MaxDepth 的不采集, 最后写入的 2026-10/18 用于确认之前的已处理
+	for _, sub := range []string{"2026-10/17", "2026-10/18/19", "2026-10/18"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		watched := filepath.Join(dir, sub)
+		if strings.Count(sub, "/") > 1 { // 超过 MaxDepth 的只会监听到上级目录
+			watched = filepath.Dir(watched)
+		}
+		waitFor(t, time.Second, func() bool { return watching(ps.watch, watched) })
+		if _, err := xfile.AppendContent(filepath.Join(dir, sub, "app.log"), "warning "+sub+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, filepath.Join(dir, "2026-10/18", "app.log")) })
+
+	got := strBuf.String()
+	if !strings.Contains(got, "warning 2026-10/17") || strings.Contains(got, "warning 2026-10/18/19") {
+		t.Errorf("got: %q", got)
+	}
+}
+
+type CountBuf struct {
+	mu    sync.Mutex
+	count int
+	buf   strings.Builder
+}
+
+func (c *CountBuf) WriteTo(bus *LogHandlerBus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	c.buf.WriteString(bus.Msg)
+}
+
+func (c *CountBuf) Get() (int, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count, c.buf.String()
+}
+
+func TestTailInterval(t *testing.T) {
+	ps, _ := NewPsLog(WithTailInterval(200 * time.Millisecond))
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	countBuf := new(CountBuf)
+	tmp := filepath.Join(t.TempDir(), "app.log")
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{countBuf},
+			},
+		},
+	}
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	begin := time.Now()
+	for i := 0; i < 100; i++ {
+		if _, err := xfile.AppendContent(tmp, "warning\n"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		_, got := countBuf.Get()
+		return strings.Count(got, "warning") == 100
+	})
+
+	// 间隔内的变化合并为一次解析
+	count, got := countBuf.Get()
+	if max := int(time.Since(begin)/(200*time.Millisecond)) + 2; count > max {
+		t.Errorf("count: %d, max: %d, got: %q", count, max, got)
+	}
+}
+
+type SlowBuf struct {
+	StrBuf
+	dur     time.Duration
+	writing int32 // 已开始写入的次数
+}
+
+func (s *SlowBuf) WriteTo(bus *LogHandlerBus) {
+	atomic.AddInt32(&s.writing, 1)
+	time.Sleep(s.dur)
+	s.StrBuf.WriteTo(bus)
+}
+
+func TestTailParallel(t *testing.T) {
+	ps, _ := NewPsLog(WithTaskPoolSize(2))
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	slowBuf := &SlowBuf{dur: time.Second}
+	strBuf := new(StrBuf)
+	for filename, to := range map[string]PsLogWriter{"slow.log": slowBuf, "fast.log": strBuf} {
+		tmp := filepath.Join(dir, filename)
+		if _, err := xfile.AppendContent(tmp, ""); err != nil {
+			t.Fatal(err)
+		}
+		handler := &Handler{
+			Change:   -1,       // 每次都持久化 offset
+			Tail:     true,     // 实时监听
+			ExpireAt: NoExpire, // 文件句柄不过期
+			Targets: []*Target{
+				{
+					Content: "warning",
+					To:      []PsLogWriter{to},
+				},
+			},
+		}
+		if err := ps.AddPath2Handler(tmp, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 慢的文件不影响其他文件
+	xfile.AppendContent(filepath.Join(dir, "slow.log"), "warning slow\n")
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt32(&slowBuf.writing) > 0 })
+	xfile.AppendContent(filepath.Join(dir, "fast.log"), "warning fast\n")
+	waitFor(t, slowBuf.dur/2, func() bool { return strings.Contains(strBuf.String(), "warning fast") })
+}
+
+func TestTailPoolSaturated(t *testing.T) {
+	// 协程池只有 1 个协程时, 解析任务中不能再提交任务到协程池
+	ps, _ := NewPsLog(WithTaskPoolSize(1), WithAsync2Tos())
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	countBuf := new(CountBuf)
+	fileInfos := make([]*FileInfo, 0, 3)
+	for _, filename := range []string{"a.log", "b.log", "c.log"} {
+		tmp := filepath.Join(dir, filename)
+		if _, err := xfile.AppendContent(tmp, ""); err != nil {
+			t.Fatal(err)
+		}
+		handler := &Handler{
+			Change:   -1,       // 每次都持久化 offset
+			Tail:     true,     // 实时监听
+			ExpireAt: NoExpire, // 文件句柄不过期
+			Targets: []*Target{
+				{
+					Content: "warning",
+					To:      []PsLogWriter{countBuf},
+				},
+			},
+		}
+		if err := ps.AddPath2Handler(tmp, handler); err != nil {
+			t.Fatal(err)
+		}
+		fileInfos = append(fileInfos, ps.logMap[tmp])
+	}
+
+	content := "warning 1\nwarning 2\n"
+	for _, fileInfo := range fileInfos {
+		if _, err := xfile.AppendContent(fileInfo.FileName(), content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		_, got := countBuf.Get()
+		return strings.Count(got, "warning") == 6
+	})
+	for _, fileInfo := range fileInfos {
+		waitFor(t, time.Second, func() bool {
+			got, _ := os.ReadFile(fileInfo.offsetFilename())
+			return string(got) == strconv.Itoa(len(content))
+		})
+	}
+}
+
+func TestTailRescan(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	dir := t.TempDir()
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+		NeedCollect: func(filename string) bool { return strings.HasSuffix(filename, ".log") },
+	}
+	if err := ps.AddDir2Handle(dir, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟事件丢失
+	ps.watch.watcher.Remove(dir)
+	if inWatchList(ps.watch, dir) {
+		t.Fatal("event should be lost")
+	}
+	if _, err := xfile.AppendContent(filepath.Join(dir, "app.log"), "warning 1\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	ps.watch.triggerRescan()
+	waitFor(t, time.Second, func() bool { return strBuf.String() != "" })
+	if got := strBuf.String(); strings.Count(got, "warning") != 1 {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestRemovePath(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	dir := t.TempDir()
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+		NeedCollect: func(filename string) bool { return strings.HasSuffix(filename, ".log") },
+	}
+	a, b := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")
+	for _, name := range []string{a, b} {
+		if _, err := xfile.AppendContent(name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.AddPath2Handler(dir, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只移除目录下的 b.log
+	if err := ps.RemovePath(b); err != nil {
+		t.Fatal(err)
+	}
+	// 先写入 b.log, a.log 解析完时 b.log 的事件已处理
+	for _, name := range []string{b, a} {
+		if _, err := xfile.AppendContent(name, "warning 1\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, a) })
+	if got := strBuf.String(); strings.Count(got, "warning") != 1 {
+		t.Errorf("got: %q", got)
+	}
+
+	// 移除整个目录
+	if err := ps.RemovePath(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	if inWatchList(ps.watch, dir) {
+		t.Error("dir should is not watched")
+	}
+	if _, err := xfile.AppendContent(a, "warning 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strBuf.String(); strings.Count(got, "warning") != 1 {
+		t.Errorf("got: %q", got)
+	}
+	if err := ps.RemovePath(dir); err == nil {
+		t.Error("it should is failed")
+	}
+}
+
+func TestIdleExpire(t *testing.T) {
+	ps, _ := NewPsLog(WithCleanUpTime(100 * time.Millisecond))
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	tmp := filepath.Join(t.TempDir(), "app.log")
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Change:     -1,   // 每次都持久化 offset
+		Tail:       true, // 实时监听
+		ExpireDur:  500 * time.Millisecond,
+		IdleExpire: true, // 空闲过期
+		Targets: []*Target{
+			{
+				Content: "warning",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+	}
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo := ps.logMap[tmp]
+
+	opened := func() bool {
+		fileInfo.mu.Lock()
+		defer fileInfo.mu.Unlock()
+		return fileInfo.fh != nil
+	}
+
+	// 持续写入, 不会过期
+	for i := 1; i <= 5; i++ {
+		if _, err := xfile.AppendContent(tmp, "warning\n"); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, time.Second, func() bool { return strings.Count(strBuf.String(), "warning") == i })
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !opened() {
+		t.Error("fh should is opened")
+	}
+
+	// 空闲后关闭句柄, 但不移除
+	waitFor(t, 2*time.Second, func() bool { return !opened() })
+	ps.rwMu.RLock()
+	_, ok := ps.logMap[tmp]
+	ps.rwMu.RUnlock()
+	if !ok {
+		t.Fatal("path should is registered")
+	}
+
+	// 再次写入时恢复
+	if _, err := xfile.AppendContent(tmp, "warning\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return strings.Count(strBuf.String(), "warning") == 6 })
+	if !opened() {
+		t.Error("fh should is reopened")
+	}
+}
+
+func TestAttachPath2Handler(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "gateway.log")
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	newHandler := func(content string, buf *StrBuf, ext string) *Handler {
+		return &Handler{
+			Change:   -1,       // 每次都持久化 offset
+			Tail:     true,     // 实时监听
+			ExpireAt: NoExpire, // 文件句柄不过期
+			Targets: []*Target{
+				{
+					Content: content,
+					To:      []PsLogWriter{buf},
+				},
+			},
+			Ext:         ext,
+			NeedCollect: func(filename string) bool { return strings.HasSuffix(filename, ".log") },
+		}
+	}
+
+	// 单文件
+	warnBuf, errBuf := new(StrBuf), new(StrBuf)
+	if err := ps.AttachPath2Handler(tmp, newHandler("warning", warnBuf, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AttachPath2Handler(tmp, newHandler("error", errBuf, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(tmp, "warning 1\nerror 1\nwarning 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool {
+		return strings.Count(warnBuf.String(), "warning") == 2 && errBuf.String() != ""
+	})
+	if got := warnBuf.String(); strings.Count(got, "warning") != 2 || strings.Contains(got, "error") {
+		t.Errorf("warning got: %q", got)
+	}
+	if got := errBuf.String(); strings.Count(got, "error") != 1 || strings.Contains(got, "warning") {
+		t.Errorf("error got: %q", got)
+	}
+
+	// 目录, 已有的文件也会附加
+	subDir := filepath.Join(dir, "sub")
+	subTmp := filepath.Join(subDir, "app.log")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(subTmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	dirWarnBuf, dirErrBuf := new(StrBuf), new(StrBuf)
+	if err := ps.AttachPath2Handler(subDir, newHandler("warning", dirWarnBuf, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AttachPath2Handler(subDir, newHandler("error", dirErrBuf, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(subTmp, "warning 1\nerror 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool {
+		return dirWarnBuf.String() != "" && dirErrBuf.String() != ""
+	})
+	if got := dirWarnBuf.String(); strings.Count(got, "warning") != 1 {
+		t.Errorf("dir warning got: %q", got)
+	}
+	if got := dirErrBuf.String(); strings.Count(got, "error") != 1 {
+		t.Errorf("dir error got: %q", got)
+	}
+}
+
+type PathBuf struct {
+	mu   sync.Mutex
+	Msgs map[string][]string // key: LogPath
+}
+
+func (p *PathBuf) WriteTo(bus *LogHandlerBus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Msgs == nil {
+		p.Msgs = make(map[string][]string)
+	}
+	p.Msgs[bus.LogPath] = append(p.Msgs[bus.LogPath], bus.Msg)
+}
+
+func (p *PathBuf) Get(path string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return strings.Join(p.Msgs[path], "")
+}
+
+func TestTailMultiPerFile(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	pathBuf := new(PathBuf)
+	dir := t.TempDir()
+	mergeRule := line.NewMulti()
+	if err := mergeRule.StartPattern(`^\[`); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Change:    -1,       // 每次都持久化 offset
+		Tail:      true,     // 实时监听
+		ExpireAt:  NoExpire, // 文件句柄不过期
+		MergeRule: mergeRule,
+		Targets: []*Target{
+			{
+				Content: "ERRO",
+				To:      []PsLogWriter{pathBuf},
+			},
+		},
+		NeedCollect: func(filename string) bool { return strings.HasSuffix(filename, ".log") },
+	}
+	a, b := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")
+	for _, name := range []string{a, b} {
+		if _, err := xfile.AppendContent(name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.AddPath2Handler(dir, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// 两个文件交替写入, 堆栈分多次写入
+	for _, name := range []string{a, b} {
+		if _, err := xfile.AppendContent(name, "[ERRO] "+filepath.Base(name)+"\n"+filepath.Base(name)+" stack 1\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, a) && parsed(ps, b) })
+	for _, name := range []string{a, b} {
+		if _, err := xfile.AppendContent(name, filepath.Base(name)+" stack 2\n[INFO] end\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		return strings.Contains(pathBuf.Get(a), "a.log stack 2") && strings.Contains(pathBuf.Get(b), "b.log stack 2")
+	})
+
+	for _, name := range []string{a, b} {
+		base, other := filepath.Base(name), "b.log"
+		if base == other {
+			other = "a.log"
+		}
+		got := pathBuf.Get(name)
+		if strings.Count(got, base+" stack") != 2 || strings.Contains(got, other) {
+			t.Errorf("%s got: %q", base, got)
+		}
+	}
+
+	// 持久化的偏移量不含合并中的内容
+	child, err := ps.logMap[dir].getFileInfo(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child.mu.Lock()
+	commit, offset := child.commitOffset(), child.loadOffset()
+	child.mu.Unlock()
+	if want := offset - int64(len("[INFO] end\n")); commit != want {
+		t.Errorf("commit offset: %d, want: %d", commit, want)
+	}
+}
+
+func TestTailMergeTimeout(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	tmp := filepath.Join(t.TempDir(), "app.log")
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	mergeRule := line.NewMulti()
+	if err := mergeRule.StartPattern(`^\[`); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Change:       -1,       // 每次都持久化 offset
+		Tail:         true,     // 实时监听
+		ExpireAt:     NoExpire, // 文件句柄不过期
+		MergeRule:    mergeRule,
+		MergeTimeout: 300 * time.Millisecond,
+		Targets: []*Target{
+			{
+				Content: "ERRO",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+	}
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// 堆栈在超时内分多次写入, 不会被拆分
+	if _, err := xfile.AppendContent(tmp, "[ERRO] panic\nstack 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, tmp) })
+	if _, err := xfile.AppendContent(tmp, "stack 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, tmp) })
+	if got := strBuf.String(); got != "" {
+		t.Errorf("it should is merging, got: %q", got)
+	}
+
+	// 超时后输出
+	waitFor(t, 2*time.Second, func() bool { return strBuf.String() != "" })
+	if got := strBuf.String(); !strings.Contains(got, "[ERRO] panic\nstack 1\nstack 2\n") {
+		t.Errorf("got: %q", got)
+	}
+	fileInfo := ps.logMap[tmp]
+	fileInfo.mu.Lock()
+	commit, offset := fileInfo.commitOffset(), fileInfo.loadOffset()
+	fileInfo.mu.Unlock()
+	if commit != offset {
+		t.Errorf("commit offset: %d, offset: %d", commit, offset)
+	}
+}
+
+func TestRemovePathMerging(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "app.log")
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	mergeRule := line.NewMulti()
+	if err := mergeRule.StartPattern(`^\[`); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Change:       -1,       // 每次都持久化 offset
+		Tail:         true,     // 实时监听
+		ExpireAt:     NoExpire, // 文件句柄不过期
+		MergeRule:    mergeRule,
+		MergeTimeout: time.Hour,
+		Targets: []*Target{
+			{
+				Content: "ERRO",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+	}
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(tmp, "[ERRO] panic\nstack\n"); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo := ps.logMap[tmp]
+	waitFor(t, 2*time.Second, func() bool { return fileInfo.loadOffset() > 0 })
+
+	// 移除时输出合并中的内容, 停止超时的定时器
+	if err := ps.RemovePath(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if got := strBuf.String(); got != "[ERRO] panic\nstack\n\n" {
+		t.Errorf("got: %q", got)
+	}
+	fileInfo.mu.Lock()
+	stopped := fileInfo.mergeTimer == nil || !fileInfo.mergeTimer.Stop()
+	fileInfo.mu.Unlock()
+	if !stopped {
+		t.Error("merge timer is not stopped")
+	}
+}
+
+func TestTailMergeFallbackOffset(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	strBuf := new(StrBuf)
+	tmp := filepath.Join(t.TempDir(), "app.log")
+	// 达到 3 行后第 1 行按单行输出, 之后的 2 行重新合并, 合并中的事件从第 2 行开始
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	mergeRule := line.NewJSON()
+	mergeRule.MaxLines(3)
+	handler := &Handler{
+		Change:       -1,       // 每次都持久化 offset
+		Tail:         true,     // 实时监听
+		ExpireAt:     NoExpire, // 文件句柄不过期
+		MergeRule:    mergeRule,
+		MergeTimeout: -1,
+		Targets: []*Target{
+			{
+				Content: "{",
+				To:      []PsLogWriter{strBuf},
+			},
+		},
+	}
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(tmp, "{\n{\nx\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	fileInfo := ps.logMap[tmp]
+	var commit, offset int64
+	waitFor(t, 2*time.Second, func() bool {
+		fileInfo.mu.Lock()
+		defer fileInfo.mu.Unlock()
+		commit, offset = fileInfo.commitOffset(), fileInfo.loadOffset()
+		return offset == int64(len("{\n{\nx\n"))
+	})
+	if commit != int64(len("{\n")) {
+		t.Errorf("commit offset: %d, offset: %d", commit, offset)
+	}
+	if got := strBuf.String(); got != "{\n\n" {
+		t.Errorf("got: %q", got)
+	}
+}
+
+type FieldsBuf struct {
+	mu    sync.Mutex
+	Buses []LogHandlerBus
+}
+
+func (f *FieldsBuf) WriteTo(bus *LogHandlerBus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Buses = append(f.Buses, LogHandlerBus{LogPath: bus.LogPath, Msg: bus.Msg, Fields: bus.Fields, Labels: bus.Labels})
+}
+
+func (f *FieldsBuf) Get() []LogHandlerBus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]LogHandlerBus(nil), f.Buses...)
+}
+
+func TestTailDocker(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	fieldsBuf := new(FieldsBuf)
+	tmp := filepath.Join(t.TempDir(), "abc-json.log")
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	mergeRule := line.NewMulti()
+	if err := mergeRule.StartPattern(`^\[`); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Change:    -1,       // 每次都持久化 offset
+		Tail:      true,     // 实时监听
+		ExpireAt:  NoExpire, // 文件句柄不过期
+		Decoder:   line.NewDocker(),
+		MergeRule: mergeRule,
+		Targets: []*Target{
+			{
+				Content: "ERRO",
+				To:      []PsLogWriter{fieldsBuf},
+			},
+		},
+	}
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := `{"log":"[ERRO] panic\n","stream":"stderr","time":"t1"}` + "\n" +
+		`{"log":"stack ","stream":"stderr","time":"t2"}` + "\n" +
+		`{"log":"1\n","stream":"stderr","time":"t3"}` + "\n" +
+		`{"log":"[INFO] ok\n","stream":"stdout","time":"t4"}` + "\n"
+	if _, err := xfile.AppendContent(tmp, rows); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, tmp) })
+
+	buses := fieldsBuf.Get()
+	if len(buses) != 1 {
+		t.Fatalf("buses: %+v", buses)
+	}
+	if buses[0].Msg != "[ERRO] panic\nstack 1\n\n" || buses[0].Fields["stream"] != "stderr" || buses[0].Fields["time"] != "t1" {
+		t.Errorf("bus: %+v", buses[0])
+	}
+
+	// 持久化的偏移量为合并中的事件开始的位置
+	fileInfo := ps.logMap[tmp]
+	fileInfo.mu.Lock()
+	commit, offset := fileInfo.commitOffset(), fileInfo.loadOffset()
+	fileInfo.mu.Unlock()
+	if want := offset - int64(len(`{"log":"[INFO] ok\n","stream":"stdout","time":"t4"}`+"\n")); commit != want {
+		t.Errorf("commit offset: %d, want: %d", commit, want)
+	}
+}
+
+func TestAddProjectLogs(t *testing.T) {
+	ps, _ := NewPsLog()
+	defer ps.Close()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	projectPath := filepath.Join(t.TempDir(), "demo")
+	tmp := filepath.Join(projectPath, "log", "app.log")
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	logPath := NewLogPath()
+	logPath.SetLabels(map[string]string{"env": "test", LabelProject: "other"})
+	projectLogs := logPath.ParseLogPath(&Project{
+		LogDir:      &LogDir{Namespace: "ns", TargetNames: []string{"app.log"}},
+		ProjectPath: projectPath,
+	})
+
+	fieldsBuf := new(FieldsBuf)
+	handler := &Handler{
+		Change:   -1,       // 每次都持久化 offset
+		Tail:     true,     // 实时监听
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Labels:   map[string]string{"team": "a"},
+		Targets: []*Target{
+			{
+				Content: "ERRO",
+				To:      []PsLogWriter{fieldsBuf},
+			},
+		},
+	}
+	if err := ps.AddProjectLogs(handler, projectLogs...); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.Labels) != 1 {
+		t.Errorf("handler labels should not change: %v", handler.Labels)
+	}
+
+	if _, err := xfile.AppendContent(tmp, "[ERRO] test\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return len(fieldsBuf.Get()) > 0 })
+
+	buses := fieldsBuf.Get()
+	if len(buses) != 1 {
+		t.Fatalf("buses: %+v", buses)
+	}
+	want := map[string]string{
+		LabelProject:   "demo",
+		LabelNamespace: "ns",
+		LabelHostname:  hostname,
+		"env":          "test",
+		"team":         "a",
+	}
+	if !reflect.DeepEqual(buses[0].Labels, want) {
+		t.Errorf("labels: %v, want: %v", buses[0].Labels, want)
+	}
+}
+
+func TestCloseSaveOffset(t *testing.T) {
+	ps, _ := NewPsLog()
+	if err := ps.TailLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := filepath.Join(t.TempDir(), "app.log")
+	if _, err := xfile.AppendContent(tmp, ""); err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Tail:     true,     // 实时监听, Change 为默认值, 不会每次都持久化 offset
+		ExpireAt: NoExpire, // 文件句柄不过期
+		Targets: []*Target{
+			{
+				Content: "ERRO",
+				To:      []PsLogWriter{new(StrBuf)},
+			},
+		},
+	}
+	if err := ps.AddPath2Handler(tmp, handler); err != nil {
+		t.Fatal(err)
+	}
+	content := "[ERRO] a\n[INFO] b\n"
+	if _, err := xfile.AppendContent(tmp, content); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, tmp) })
+
+	ps.rwMu.RLock()
+	fileInfo := ps.logMap[tmp]
+	ps.rwMu.RUnlock()
+	ps.Close()
+	offset, err := os.ReadFile(fileInfo.offsetFilename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(offset) != fmt.Sprint(len(content)) {
+		t.Errorf("offset: %q", offset)
 	}
 }
@@ -0,0 +1,358 @@
+package pslog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	plg "gitee.com/xuesongtao/ps-log/log"
+)
+
+const (
+	defaultReloadDelay = 200 * time.Millisecond // 配置文件变化后延迟加载, 合并编辑器多次写入
+)
+
+// Reloader 根据配置文件运行 PsLog, 支持热加载
+// 说明:
+//  1. 加载时对比新旧配置: 删除的 path 会移除, 新增的会添加, handler 或其引用的 writer 有变化的会替换
+//  2. 替换时保留偏移量和文件句柄, 旧 handler 合并中的内容会先输出; 没有变化的 path 不受影响
+//  3. 新配置校验不通过或修改 PsLog 失败时, 回滚已修改的 path, 继续使用旧配置
+//  4. options 的变化需要重启才会生效
+type Reloader struct {
+	filename  string
+	ps        *PsLog
+	mu        sync.Mutex
+	config    *Config
+	writers   map[string]PsLogWriter // key: writers 中的实例名
+	watch     *Watch
+	sigCh     chan os.Signal
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReloader 加载配置文件并运行, 注: 结束时需要调用 Close
+func NewReloader(filename string) (*Reloader, error) {
+	c, err := LoadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewReloaderFromConfig(filename, c)
+}
+
+// NewReloaderFromConfig 使用已加载的配置运行, 防止重复读取, filename 为之后重新加载的配置文件, 注: 结束时需要调用 Close
+func NewReloaderFromConfig(filename string, c *Config) (*Reloader, error) {
+	if c == nil {
+		return nil, errors.New("config is nil")
+	}
+	if err := c.Valid(); err != nil {
+		return nil, err
+	}
+	writers, err := c.buildWriters()
+	if err != nil {
+		return nil, err
+	}
+	ps, err := c.newPsLog(writers)
+	if err != nil {
+		closeWriters(writers)
+		return nil, err
+	}
+	return &Reloader{
+		filename: filepath.Clean(filename),
+		ps:       ps,
+		config:   c,
+		writers:  writers,
+		closeCh:  make(chan struct{}),
+	}, nil
+}
+
+// PsLog 运行中的 PsLog
+func (r *Reloader) PsLog() *PsLog {
+	return r.ps
+}
+
+// Done 关闭或 PsLog 被关闭后返回的 chan 会被关闭
+func (r *Reloader) Done() <-chan struct{} {
+	return r.ps.Done()
+}
+
+// Config 当前生效的配置
+func (r *Reloader) Config() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config
+}
+
+// Reload 重新加载配置文件
+func (r *Reloader) Reload() error {
+	c, err := LoadConfig(r.filename)
+	if err != nil {
+		return err
+	}
+	return r.Apply(c)
+}
+
+// Apply 应用新的配置, 先创建所有的 writer 和 handler, 都成功后再修改 PsLog, 修改失败时回滚
+func (r *Reloader) Apply(c *Config) error {
+	if c == nil {
+		return errors.New("config is nil")
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.config
+	if !reflect.DeepEqual(old.Options, c.Options) {
+		plg.Warning("options is changed, it will take effect after restart")
+	}
+
+	// writer 没有变化的复用
+	writers := make(map[string]PsLogWriter, len(c.Writers))
+	changedWriters := make(map[string]bool)
+	for name, wc := range c.Writers {
+		if oldWc, ok := old.Writers[name]; ok && reflect.DeepEqual(oldWc, wc) {
+			writers[name] = r.writers[name]
+			continue
+		}
+		changedWriters[name] = true
+	}
+	if len(changedWriters) > 0 {
+		tmp := &Config{Writers: make(map[string]*WriterConfig, len(changedWriters))}
+		for name := range changedWriters {
+			tmp.Writers[name] = c.Writers[name]
+		}
+		created, err := tmp.buildWriters()
+		if err != nil {
+			return err
+		}
+		for name, writer := range created {
+			writers[name] = writer
+		}
+	}
+
+	// 对比 path
+	oldPaths := make(map[string]*PathConfig, len(old.Paths))
+	for _, path := range old.Paths {
+		oldPaths[path.key()] = path
+	}
+	type change struct {
+		path    *PathConfig
+		handler *Handler
+		replace bool
+	}
+	changes := make([]*change, 0, len(c.Paths))
+	for i, path := range c.Paths {
+		oldPath, ok := oldPaths[path.key()]
+		delete(oldPaths, path.key())
+		if ok && reflect.DeepEqual(oldPath, path) && !c.handlerChanged(old, path.Handler, changedWriters) {
+			continue
+		}
+
+		var errs ConfigErrors
+		handler := c.buildHandler(path.Handler, writers, &errs)
+		if err := errs.err(); err != nil {
+			r.closeCreated(writers, changedWriters)
+			return &ConfigError{Key: fmt.Sprintf("paths[%d]", i), Err: err}
+		}
+		changes = append(changes, &change{path: path, handler: handler, replace: ok})
+	}
+
+	// 修改 PsLog, 有失败时按旧的配置回滚已修改的 path, 继续使用旧的配置
+	undos := make([]func() error, 0, len(oldPaths)+len(changes))
+	for key, path := range oldPaths {
+		if err := r.ps.RemovePath(key); err != nil {
+			plg.Errorf("RemovePath %q is failed, err: %v", key, err)
+			continue
+		}
+		path := path
+		undos = append(undos, func() error { return r.restorePath(old, path, false) })
+	}
+	var errs ConfigErrors
+	for _, v := range changes {
+		v := v
+		if v.replace {
+			// 替换失败时可能已部分修改, 先记录回滚
+			oldPath := old.findPath(v.path.key())
+			undos = append(undos, func() error { return r.restorePath(old, oldPath, true) })
+		}
+		if err := r.setPath(v.path, v.handler, v.replace); err != nil {
+			errs.add(v.path.key(), err)
+			break
+		}
+		if !v.replace {
+			// 新增成功后才需要移除, 失败时 RemovePath 可能移除已有目录下的文件
+			undos = append(undos, func() error { return r.ps.RemovePath(v.path.key()) })
+		}
+	}
+	if err := errs.err(); err != nil {
+		for i := len(undos) - 1; i >= 0; i-- {
+			if e := undos[i](); e != nil {
+				plg.Errorf("rollback is failed, err: %v", e)
+			}
+		}
+		r.closeCreated(writers, changedWriters)
+		return err
+	}
+
+	// 关闭不再使用的 writer
+	for name, writer := range r.writers {
+		if _, ok := c.Writers[name]; !ok || changedWriters[name] {
+			closeWriter(name, writer)
+		}
+	}
+	r.config = c
+	r.writers = writers
+	plg.Infof("config is reloaded, removed: %d, changed: %d", len(oldPaths), len(changes))
+	return nil
+}
+
+// setPath 按配置添加或替换 path
+func (r *Reloader) setPath(path *PathConfig, handler *Handler, replace bool) error {
+	switch {
+	case path.Glob != "" && replace:
+		return r.ps.ReplaceGlob(path.Glob, handler)
+	case path.Glob != "":
+		return r.ps.AddGlob(path.Glob, handler)
+	case replace:
+		return r.ps.ReplacePath2Handler(path.Path, handler)
+	default:
+		return r.ps.AddPath2Handler(path.Path, handler)
+	}
+}
+
+// restorePath 回滚时按旧的配置恢复 path, 使用旧的 writer
+func (r *Reloader) restorePath(old *Config, path *PathConfig, replace bool) error {
+	var errs ConfigErrors
+	handler := old.buildHandler(path.Handler, r.writers, &errs)
+	if err := errs.err(); err != nil {
+		return err
+	}
+	return r.setPath(path, handler, replace)
+}
+
+// handlerChanged handler 的配置或其引用的 writer 是否有变化
+func (c *Config) handlerChanged(old *Config, name string, changedWriters map[string]bool) bool {
+	hc := c.Handlers[name]
+	if !reflect.DeepEqual(old.Handlers[name], hc) {
+		return true
+	}
+	for _, target := range hc.Targets {
+		for _, to := range target.To {
+			if changedWriters[to] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// closeCreated 加载失败时, 关闭新创建的 writer
+func (r *Reloader) closeCreated(writers map[string]PsLogWriter, created map[string]bool) {
+	for name := range created {
+		if writer, ok := writers[name]; ok {
+			closeWriter(name, writer)
+		}
+	}
+}
+
+// WatchFile 配置文件变化时自动重新加载
+func (r *Reloader) WatchFile() error {
+	if r.watch != nil {
+		return nil
+	}
+	watch, err := NewWatch()
+	if err != nil {
+		return err
+	}
+	if err := watch.Add(r.filename); err != nil {
+		watch.Close()
+		return err
+	}
+	r.watch = watch
+	busCh := make(chan *WatchFileInfo, 1<<4)
+	watch.Watch(busCh)
+	go func() {
+		var timer *time.Timer
+		for range busCh {
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(defaultReloadDelay, r.reload)
+		}
+	}()
+	return nil
+}
+
+// WatchSignal 收到信号时重新加载, 默认 SIGHUP
+func (r *Reloader) WatchSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sigs...)
+	r.sigCh = sigCh
+	go func() {
+		for {
+			select {
+			case sig := <-sigCh:
+				plg.Infof("receive signal %q, it will reload", sig)
+				r.reload()
+			case <-r.closeCh:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Reloader) reload() {
+	select {
+	case <-r.closeCh:
+		return
+	default:
+	}
+	if err := r.Reload(); err != nil {
+		plg.Errorf("reload %q is failed, it will use the old config, err: %v", r.filename, err)
+	}
+}
+
+// Close 停止加载, 关闭 PsLog 和 writer
+func (r *Reloader) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closeCh)
+		if r.sigCh != nil {
+			signal.Stop(r.sigCh)
+		}
+		if r.watch != nil {
+			r.watch.Close()
+		}
+		r.ps.Close()
+		r.mu.Lock()
+		closeWriters(r.writers)
+		r.mu.Unlock()
+	})
+}
+
+func closeWriters(writers map[string]PsLogWriter) {
+	for name, writer := range writers {
+		closeWriter(name, writer)
+	}
+}
+
+// closeWriter 关闭实现了 io.Closer 的 writer
+func closeWriter(name string, writer PsLogWriter) {
+	closer, ok := writer.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		plg.Errorf("close writer %q is failed, err: %v", name, err)
+	}
+}
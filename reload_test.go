@@ -0,0 +1,367 @@
+package pslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitee.com/xuesongtao/gotool/xfile"
+)
+
+const reloadConfig = `
+options:
+  tail: true
+writers:
+  buf:
+    type: reload
+handlers:
+  app:
+    tail: true
+    change: -1
+    expire_at: never
+    merge_timeout: -1
+    merge:
+      type: multi
+      pattern: ^\[
+    targets:
+      - content: %s
+        to: [buf]
+paths:
+%s`
+
+func writeReloadConfig(t *testing.T, filename, content string, paths ...string) {
+	pathConfig := ""
+	for _, path := range paths {
+		pathConfig += fmt.Sprintf("  - path: %s\n    handler: app\n", path)
+	}
+	if err := os.WriteFile(filename, []byte(fmt.Sprintf(reloadConfig, content, pathConfig)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	strBuf := new(StrBuf)
+	RegisterWriter("reload", func(params map[string]interface{}) (PsLogWriter, error) {
+		return strBuf, nil
+	})
+
+	dir := t.TempDir()
+	a, b, c := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log"), filepath.Join(dir, "c.log")
+	for _, name := range []string{a, b, c} {
+		if _, err := xfile.AppendContent(name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename := filepath.Join(dir, "pslog.yaml")
+	writeReloadConfig(t, filename, "ERRO", a, b)
+	r, err := NewReloader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ps := r.PsLog()
+
+	// 合并中的内容
+	if _, err := xfile.AppendContent(a, "[ERRO] a\nstack\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, a) })
+	if got := strBuf.String(); got != "" {
+		t.Fatalf("got: %q", got)
+	}
+	ps.rwMu.RLock()
+	fileInfo := ps.logMap[a]
+	ps.rwMu.RUnlock()
+	fh := fileInfo.fh
+
+	// 修改 target, 移除 b, 新增 c
+	writeReloadConfig(t, filename, "WARN", a, c)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strBuf.String(); strings.Count(got, "[ERRO] a\nstack") != 1 {
+		t.Errorf("merging should is flushed, got: %q", got)
+	}
+	ps.rwMu.RLock()
+	_, hasB := ps.logMap[b]
+	_, hasC := ps.logMap[c]
+	newFileInfo := ps.logMap[a]
+	ps.rwMu.RUnlock()
+	if hasB || !hasC {
+		t.Errorf("hasB: %v, hasC: %v", hasB, hasC)
+	}
+	if newFileInfo != fileInfo || fileInfo.fh != fh || fileInfo.loadOffset() != int64(len("[ERRO] a\nstack\n")) {
+		t.Errorf("offset and fh should keep, offset: %d", fileInfo.loadOffset())
+	}
+
+	strBuf.Reset()
+	if _, err := xfile.AppendContent(a, "[ERRO] b\n[WARN] c\n[INFO] d\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(c, "[WARN] e\n[INFO] f\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(ps, a) && parsed(ps, c) })
+	if got := strBuf.String(); strings.Contains(got, "ERRO") || !strings.Contains(got, "[WARN] c") || !strings.Contains(got, "[WARN] e") {
+		t.Errorf("got: %q", got)
+	}
+
+	// 配置有误时, 使用旧的配置
+	if err := os.WriteFile(filename, []byte("paths:\n  - path: "+a+"\n    handler: none\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil || !strings.Contains(err.Error(), "paths[0].handler") {
+		t.Errorf("err: %v", err)
+	}
+	if r.Config().Handlers["app"].Targets[0].Content != "WARN" {
+		t.Error("config should not change")
+	}
+}
+
+func TestReloaderWatchFile(t *testing.T) {
+	strBuf := new(StrBuf)
+	RegisterWriter("reload", func(params map[string]interface{}) (PsLogWriter, error) {
+		return strBuf, nil
+	})
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.log")
+	if _, err := xfile.AppendContent(a, ""); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "pslog.yaml")
+	writeReloadConfig(t, filename, "ERRO", a)
+	r, err := NewReloader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.WatchFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeReloadConfig(t, filename, "WARN", a)
+	waitFor(t, defaultReloadDelay+time.Second, func() bool { return r.Config().Handlers["app"].Targets[0].Content == "WARN" })
+	if _, err := xfile.AppendContent(a, "[ERRO] a\n[WARN] b\n[INFO] c\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return parsed(r.PsLog(), a) })
+	if got := strBuf.String(); got != "[WARN] b\n\n" {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestReloaderRollback(t *testing.T) {
+	strBuf := new(StrBuf)
+	RegisterWriter("reload", func(params map[string]interface{}) (PsLogWriter, error) {
+		return strBuf, nil
+	})
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.log")
+	if _, err := xfile.AppendContent(a, ""); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "pslog.yaml")
+	writeReloadConfig(t, filename, "ERRO", a)
+	r, err := NewReloader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// a 替换成功后, 添加目录失败(没有 need_collect), 需要回滚 a
+	writeReloadConfig(t, filename, "WARN", a, t.TempDir())
+	if err := r.Reload(); err == nil {
+		t.Fatal("reload should is failed")
+	}
+	if got := r.Config().Handlers["app"].Targets[0].Content; got != "ERRO" {
+		t.Errorf("config should not change, content: %q", got)
+	}
+	if _, err := xfile.AppendContent(a, "[ERRO] a\n[WARN] b\n[INFO] c\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return strBuf.String() != "" })
+	if got := strBuf.String(); got != "[ERRO] a\n\n" {
+		t.Errorf("got: %q", got)
+	}
+
+	// 修正后再次加载, 可以生效
+	writeReloadConfig(t, filename, "WARN", a)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	strBuf.Reset()
+	if _, err := xfile.AppendContent(a, "[ERRO] d\n[WARN] e\n[INFO] f\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return strBuf.String() != "" })
+	if got := strBuf.String(); got != "[WARN] e\n\n" {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestReloaderGlobConflict(t *testing.T) {
+	strBuf := new(StrBuf)
+	RegisterWriter("reload", func(params map[string]interface{}) (PsLogWriter, error) {
+		return strBuf, nil
+	})
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.log")
+	if _, err := xfile.AppendContent(a, ""); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "pslog.yaml")
+	write := func(patterns ...string) {
+		pathConfig := ""
+		for _, pattern := range patterns {
+			pathConfig += fmt.Sprintf("  - glob: %s\n    handler: app\n", pattern)
+		}
+		if err := os.WriteFile(filename, []byte(fmt.Sprintf(reloadConfig, "ERRO", pathConfig)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "*.log"))
+	r, err := NewReloader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// 新增的 pattern 和已有的对应同一个目录, 不能替换已有的
+	write(filepath.Join(dir, "*.log"), filepath.Join(dir, "*.txt"))
+	if err := r.Reload(); err == nil {
+		t.Fatal("reload should is failed")
+	}
+	if _, err := xfile.AppendContent(a, "[ERRO] a\n[INFO] b\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return strBuf.String() != "" })
+	if got := strBuf.String(); got != "[ERRO] a\n\n" {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestReloaderRecursive(t *testing.T) {
+	strBuf := new(StrBuf)
+	RegisterWriter("reload", func(params map[string]interface{}) (PsLogWriter, error) {
+		return strBuf, nil
+	})
+
+	dir := t.TempDir()
+	logDir := filepath.Join(dir, "log")
+	top, sub := filepath.Join(logDir, "a.log"), filepath.Join(logDir, "sub", "b.log")
+	if err := os.MkdirAll(filepath.Dir(sub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{top, sub} {
+		if _, err := xfile.AppendContent(name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := `
+options:
+  tail: true
+writers:
+  buf:
+    type: reload
+handlers:
+  app:
+    tail: true
+    change: -1
+    expire_at: never
+    merge_timeout: -1
+    recursive: %v
+    need_collect: ['\.log$']
+    targets:
+      - content: ERRO
+        to: [buf]
+paths:
+  - path: %s
+    handler: app
+`
+	filename := filepath.Join(dir, "pslog.yaml")
+	write := func(recursive bool) {
+		if err := os.WriteFile(filename, []byte(fmt.Sprintf(config, recursive, logDir)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(false)
+	r, err := NewReloader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := xfile.AppendContent(top, "[ERRO] a\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return strBuf.String() != "" })
+
+	// 开启递归后, 子目录的文件开始采集, 已采集的文件继续采集
+	write(true)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(sub, "[ERRO] b\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfile.AppendContent(top, "[ERRO] c\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool {
+		got := strBuf.String()
+		return strings.Contains(got, "[ERRO] b") && strings.Contains(got, "[ERRO] c")
+	})
+	if got := strBuf.String(); strings.Count(got, "[ERRO] a") != 1 {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestNewReloaderFromConfig(t *testing.T) {
+	RegisterWriter("reload", func(params map[string]interface{}) (PsLogWriter, error) {
+		return new(StrBuf), nil
+	})
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.log")
+	if _, err := xfile.AppendContent(a, ""); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "pslog.yaml")
+	writeReloadConfig(t, filename, "ERRO", a)
+	c, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 使用已加载的配置, 不再读取配置文件
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReloaderFromConfig(filename, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Config() != c {
+		t.Error("config should is same")
+	}
+
+	// PsLog 被关闭后 Done 返回
+	select {
+	case <-r.Done():
+		t.Fatal("it should is running")
+	default:
+	}
+	r.PsLog().Close()
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second):
+		t.Error("done is not closed")
+	}
+}
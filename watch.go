@@ -2,10 +2,14 @@ package pslog
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
 
 	"gitee.com/xuesongtao/gotool/base"
 	plg "gitee.com/xuesongtao/ps-log/log"
@@ -13,17 +17,69 @@ import (
 	tw "github.com/olekukonko/tablewriter"
 )
 
+const (
+	defaultPollInterval = time.Second // 默认轮询间隔
+)
+
+// OverflowPolicy busCh 满了时的处理策略
+type OverflowPolicy int
+
+const (
+	OverflowBlock  OverflowPolicy = iota // 阻塞等待, 默认; 处理太慢时 inotify 队列可能溢出, 溢出后会全量扫描
+	OverflowRescan                       // 丢弃事件, 并通知全量扫描, 不会阻塞 inotify
+)
+
+// WatchOpt
+type WatchOpt func(*Watch)
+
+// WithWatchOverflow 设置 busCh 满了时的处理策略
+func WithWatchOverflow(policy OverflowPolicy) WatchOpt {
+	return func(w *Watch) {
+		w.overflow = policy
+	}
+}
+
+// WithWatchPollInterval 设置轮询间隔
+func WithWatchPollInterval(dur time.Duration) WatchOpt {
+	return func(w *Watch) {
+		w.pollInterval = dur
+	}
+}
+
+// WithWatchAutoPoll 开启自动轮询, 已修改的 path 连续未收到事件时(如: NFS), 改为轮询
+func WithWatchAutoPoll() WatchOpt {
+	return func(w *Watch) {
+		w.autoPoll = true
+	}
+}
+
 // Watch 监听的文件
 type Watch struct {
-	fileMap map[string]*WatchFileInfo // key: file path
-	watcher *fs.Watcher               // 监听
+	mu           sync.RWMutex
+	autoPoll     bool                      // 是否自动轮询
+	overflow     OverflowPolicy            // busCh 满了时的处理策略
+	pollInterval time.Duration             // 轮询间隔
+	fileMap      map[string]*WatchFileInfo // key: file path
+	pendingMap   map[string]*WatchFileInfo // 还不存在的 path, 出现后移到 fileMap, key: file path
+	subDirMap    map[string]string         // 递归监听的子目录, key: 子目录 path, value: fileMap 中的目录 path
+	pollMap      map[string]*pollState     // 轮询的 path 状态, key: file path
+	watcher      *fs.Watcher               // 监听
+	rescanCh     chan struct{}             // 事件丢失时通知全量扫描
+	closeCh      chan struct{}
+	closeOnce    sync.Once
+	closed       bool // 是否已关闭, 关闭后不能再添加, 由 mu 保护
+	pollWg       sync.WaitGroup
 }
 
 // WatchFileInfo
 type WatchFileInfo struct {
-	IsDir bool   // 是否为目录
-	Dir   string // 原始目录路径
-	Path  string // 原始添加的文件路径, 这里可能是文件路径或目录路径
+	IsDir     bool   // 是否为目录
+	Pending   bool   // 是否还不存在, 为 true 时 Dir 为最近的已存在的上级目录
+	Recursive bool   // 是否递归监听子目录, 只对目录有效
+	Poll      bool   // 是否轮询, 用于不支持 inotify 的文件系统(如: NFS)
+	MaxDepth  int    // 递归时文件相对 Path 的最大层级, 0 为不限制
+	Dir       string // 原始目录路径
+	Path      string // 原始添加的文件路径, 这里可能是文件路径或目录路径
 
 	// 动态参数
 	Op              fs.Op
@@ -31,25 +87,42 @@ type WatchFileInfo struct {
 }
 
 func (w *WatchFileInfo) copy() *WatchFileInfo {
+	if w == nil {
+		return nil
+	}
 	return &WatchFileInfo{
-		IsDir: w.IsDir,
-		Dir:   w.Dir,
-		Path:  w.Path,
+		IsDir:     w.IsDir,
+		Recursive: w.Recursive,
+		Poll:      w.Poll,
+		MaxDepth:  w.MaxDepth,
+		Dir:       w.Dir,
+		Path:      w.Path,
 		// IsRename:        w.IsRename,
 		// ChangedFilename: w.ChangedFilename,
 	}
 }
 
 // NewWatch 监听
-func NewWatch() (*Watch, error) {
+func NewWatch(opts ...WatchOpt) (*Watch, error) {
 	watcher, err := fs.NewWatcher()
 	if err != nil {
 		return nil, fmt.Errorf("fs.NewWatcher is failed, err:%v", err)
 	}
 
 	obj := &Watch{
-		fileMap: make(map[string]*WatchFileInfo),
-		watcher: watcher,
+		fileMap:    make(map[string]*WatchFileInfo),
+		pendingMap: make(map[string]*WatchFileInfo),
+		subDirMap:  make(map[string]string),
+		pollMap:    make(map[string]*pollState),
+		watcher:    watcher,
+		rescanCh:   make(chan struct{}, 1),
+		closeCh:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(obj)
+	}
+	if obj.pollInterval <= 0 {
+		obj.pollInterval = defaultPollInterval
 	}
 	return obj, nil
 }
@@ -59,63 +132,284 @@ func NewWatch() (*Watch, error) {
 //  1. 自动去重
 //  2. paths 中可以为目录和文件
 //  3. 建议使用绝对路径
+//  4. path 不存在时, 会监听最近的已存在的上级目录, 等 path 被创建后再监听
 func (w *Watch) Add(paths ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errors.New("watch is closed")
+	}
 	for _, path := range paths {
-		path = filepath.Clean(path)
-		st, err := os.Lstat(path)
-		if err != nil {
+		if err := w.addPath(&WatchFileInfo{Path: filepath.Clean(path)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddRecursive 添加待递归 watch 的目录, 已有的和新建的子目录都会被监听
+// maxDepth 为文件相对 dir 的最大层级, 如: dir/a/b.log 为 2, 0 为不限制
+func (w *Watch) AddRecursive(dir string, maxDepth int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errors.New("watch is closed")
+	}
+	return w.addPath(&WatchFileInfo{Path: filepath.Clean(dir), Recursive: true, MaxDepth: maxDepth})
+}
+
+// addPath 保存和监听 path, 已存在的跳过
+func (w *Watch) addPath(info *WatchFileInfo) error {
+	if _, ok := w.fileMap[info.Path]; ok {
+		return nil
+	}
+	if _, ok := w.pendingMap[info.Path]; ok {
+		return nil
+	}
+	st, err := os.Lstat(info.Path)
+	if err != nil {
+		if !os.IsNotExist(err) {
 			return fmt.Errorf("os.Lstat is failed, err: %v", err)
 		}
-		if _, ok := w.fileMap[path]; ok {
+		return w.addPending(info)
+	}
+	info.IsDir = st.IsDir()
+	return w.add(info)
+}
+
+// add 保存和监听已存在的 path
+func (w *Watch) add(info *WatchFileInfo) error {
+	info.Pending = false
+	info.Dir = info.Path
+	if !info.IsDir {
+		info.Dir = filepath.Dir(info.Path)
+	}
+
+	// 保存和监听
+	w.fileMap[info.Path] = info
+	var err error
+	if !info.Poll {
+		// 只监听目录
+		err = w.watcher.Add(info.Dir)
+		if err == nil && info.IsDir && info.Recursive {
+			err = w.addSubDirs(info, info.Path)
+		}
+	}
+	if err != nil {
+		// inotify 不可用(如: 文件系统不支持, 超过 max_user_watches), 改为轮询
+		plg.Warningf("watch %q is failed, it will poll, err: %v", info.Path, err)
+		info.Poll = true
+	}
+	if info.Poll || w.autoPoll {
+		w.pollMap[info.Path] = newPollState(info)
+	}
+	return nil
+}
+
+// addPending 保存还不存在的 path, 并监听最近的已存在的上级目录
+func (w *Watch) addPending(info *WatchFileInfo) error {
+	dir := existParentDir(info.Path)
+	if !info.Poll {
+		if err := w.watcher.Add(dir); err != nil {
+			plg.Warningf("watch %q is failed, it will poll, err: %v", dir, err)
+			info.Poll = true
+		}
+	}
+	delete(w.pollMap, info.Path)
+	info.Pending = true
+	info.Dir = dir
+	w.pendingMap[info.Path] = info
+	return nil
+}
+
+// addSubDirs 递归监听 dir 及其子目录, 会跳过 saveOffsetDir 和超过 MaxDepth 的目录
+func (w *Watch) addSubDirs(info *WatchFileInfo, dir string) error {
+	return filepath.Walk(dir, func(path string, st os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !st.IsDir() || path == info.Path {
+			return nil
+		}
+		if st.Name() == saveOffsetDir || !dirInDepth(info.Path, path, info.MaxDepth) {
+			return filepath.SkipDir
+		}
+		if err := w.watcher.Add(path); err != nil {
+			return fmt.Errorf("w.watcher.Add is failed, err: %v", err)
+		}
+		w.subDirMap[path] = info.Path
+		return nil
+	})
+}
+
+// removeSubDirs 取消监听 dir 及其下的子目录
+func (w *Watch) removeSubDirs(dir string) {
+	for sub := range w.subDirMap {
+		if sub != dir && !strings.HasPrefix(sub, dir+string(filepath.Separator)) {
+			continue
+		}
+		// 目录被删除后会自动取消监听, 这里忽略错误
+		_ = w.watcher.Remove(sub)
+		delete(w.subDirMap, sub)
+	}
+}
+
+// syncSubDirs 递归监听的目录下, 子目录新建时监听, 删除或重命名时取消监听
+func (w *Watch) syncSubDirs(op fs.Op, root, filename string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if isRemove(op) || isRename(op) {
+		w.removeSubDirs(filename)
+		return
+	}
+	info, ok := w.fileMap[root]
+	if !ok || !isCreate(op) || !isDirPath(filename) {
+		return
+	}
+	if err := w.addSubDirs(info, filename); err != nil {
+		plg.Errorf("w.addSubDirs %q is failed, err: %v", filename, err)
+	}
+}
+
+// activatePending 处理 created 事件, 返回已出现的 pending path
+// 说明:
+//  1. created 为 pending path 时, 转为正常监听
+//  2. created 为 pending path 的上级目录时, 改为监听更近的上级目录(如: mkdir -p 后目标文件也可能已创建)
+func (w *Watch) activatePending(created string) []*WatchFileInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var res []*WatchFileInfo
+	for path, info := range w.pendingMap {
+		if path != created && !strings.HasPrefix(path, created+string(filepath.Separator)) {
 			continue
 		}
-		watchFileInfo := &WatchFileInfo{IsDir: false, Path: path, Dir: path}
-		if st.IsDir() {
-			watchFileInfo.IsDir = true
+
+		oldDir := info.Dir
+		st, err := os.Lstat(path)
+		if err == nil {
+			delete(w.pendingMap, path)
+			info.IsDir = st.IsDir()
+			if err := w.add(info); err != nil {
+				plg.Errorf("activate %q is failed, err: %v", path, err)
+				continue
+			}
+			tmp := info.copy()
+			tmp.Op = fs.Create
+			tmp.ChangedFilename = path
+			res = append(res, tmp)
 		} else {
-			watchFileInfo.Dir = filepath.Dir(path)
+			dir := existParentDir(path)
+			if dir == oldDir || info.Poll {
+				continue
+			}
+			if err := w.watcher.Add(dir); err != nil {
+				plg.Errorf("w.watcher.Add %q is failed, err: %v", dir, err)
+				continue
+			}
+			info.Dir = dir
 		}
+		w.unwatchUnused(oldDir)
+	}
+	return res
+}
 
-		// 保存和监听
-		w.fileMap[path] = watchFileInfo
-		// 只监听目录
-		if err := w.watcher.Add(watchFileInfo.Dir); err != nil {
-			return fmt.Errorf("w.watcher.Add is failed, err: %v", err)
+// unwatchUnused 目录没有被 fileMap, pendingMap 和 subDirMap 使用时, 取消监听
+func (w *Watch) unwatchUnused(dir string) {
+	for _, info := range w.fileMap {
+		if info.Dir == dir {
+			return
 		}
 	}
-	return nil
+	for _, info := range w.pendingMap {
+		if info.Dir == dir {
+			return
+		}
+	}
+	if _, ok := w.subDirMap[dir]; ok {
+		return
+	}
+	if err := w.watcher.Remove(dir); err != nil {
+		plg.Warningf("w.watcher.Remove %q is failed, err: %v", dir, err)
+	}
 }
 
 // Remove 移除待 watch 的路径
 func (w *Watch) Remove(paths ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	for _, path := range paths {
 		path = filepath.Clean(path)
+		if info, ok := w.pendingMap[path]; ok {
+			delete(w.pendingMap, path)
+			w.unwatchUnused(info.Dir)
+			continue
+		}
 		info, ok := w.fileMap[path]
-		if ok && info.IsDir {
-			if err := w.watcher.Remove(info.Dir); err != nil {
-				return fmt.Errorf("w.watcher.Remove is failed, err: %v", err)
-			}
+		if !ok {
+			continue
 		}
 		delete(w.fileMap, path)
+		delete(w.pollMap, path)
+		if info.IsDir {
+			w.removeSubDirs(path)
+		}
+		// 目录可能还被其他 path 使用, 如: 同一目录下的其他文件
+		if !info.Poll {
+			w.unwatchUnused(info.Dir)
+		}
 	}
 	return nil
 }
 
+// existParentDir 查询 path 最近的已存在的上级目录
+func existParentDir(path string) string {
+	dir := filepath.Dir(path)
+	for {
+		if st, err := os.Stat(dir); err == nil && st.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
 // Close
 func (w *Watch) Close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	w.closeOnce.Do(func() { close(w.closeCh) })
 	w.watcher.Close()
 	// w.fileMap = nil
 }
 
 // Watch 文件异步监听
 func (w *Watch) Watch(busCh chan *WatchFileInfo) {
+	w.pollWg.Add(1)
+	go w.poll(busCh)
+
 	go func() {
 		defer func() {
 			if err := recover(); err != nil {
 				plg.Error("Watch recover err:", debug.Stack())
 			}
+			// 等轮询退出后再关闭, 防止向已关闭的 busCh 发送
+			w.closeOnce.Do(func() { close(w.closeCh) })
+			w.pollWg.Wait()
 			close(busCh)
+			w.mu.Lock()
+			w.closed = true
 			w.fileMap = nil
+			w.pendingMap = nil
+			w.subDirMap = nil
+			w.pollMap = nil
+			w.mu.Unlock()
 		}()
 
 		for {
@@ -126,13 +420,23 @@ func (w *Watch) Watch(busCh chan *WatchFileInfo) {
 					return
 				}
 				plg.Error("watch err:", err)
+				// inotify 队列溢出, 事件已丢失
+				if err == fs.ErrEventOverflow {
+					w.triggerRescan()
+				}
 			case event, ok := <-w.watcher.Events:
 				if !ok {
 					plg.Info("event channel is closed")
 					return
 				}
 
-				if !w.inEvenOps(event.Op, fs.Write, fs.Rename) {
+				op := event.Op
+				// 待创建的 path 出现了
+				if isCreate(op) && w.emitActivated(busCh, event.Name) {
+					continue
+				}
+
+				if !w.inEvenOps(op, fs.Write, fs.Rename, fs.Create, fs.Remove) {
 					continue
 				}
 
@@ -140,37 +444,155 @@ func (w *Watch) Watch(busCh chan *WatchFileInfo) {
 				if watchFileInfo == nil {
 					continue
 				}
-				watchFileInfo.Op = event.Op
-				watchFileInfo.ChangedFilename = event.Name
-				// plg.Infof("filename: %q, op: %s, watch: %s", event.Name, event.Op.String(), base.GetJson2Dump(watchFileInfo))
-				// plg.Infof("filename: %q, op: %s", event.Name, event.Op.String())
-				busCh <- watchFileInfo
+				w.markEvent(watchFileInfo.Path)
+				w.dispatch(busCh, watchFileInfo, op, event.Name)
 			}
 		}
 	}()
 }
 
+// dispatch 发送 path 的变化
+func (w *Watch) dispatch(busCh chan *WatchFileInfo, watchFileInfo *WatchFileInfo, op fs.Op, filename string) {
+	if watchFileInfo.Recursive && !watchFileInfo.Poll {
+		w.syncSubDirs(op, watchFileInfo.Path, filename)
+	}
+
+	// 监听的 path 被删除, 等重新创建后再监听
+	if isRemove(op) && watchFileInfo.Path == filename {
+		w.toPending(filename)
+	}
+	watchFileInfo.Op = op
+	watchFileInfo.ChangedFilename = filename
+	// plg.Infof("filename: %q, op: %s, watch: %s", filename, op.String(), base.GetJson2Dump(watchFileInfo))
+	w.send(busCh, watchFileInfo)
+}
+
+// send 发送到 busCh, 关闭后放弃发送; busCh 满了时按 overflow 处理
+func (w *Watch) send(busCh chan *WatchFileInfo, watchFileInfo *WatchFileInfo) bool {
+	if w.overflow == OverflowRescan {
+		select {
+		case busCh <- watchFileInfo:
+			return true
+		case <-w.closeCh:
+			return false
+		default:
+			w.triggerRescan()
+			return false
+		}
+	}
+
+	select {
+	case busCh <- watchFileInfo:
+		return true
+	case <-w.closeCh:
+		return false
+	}
+}
+
+// RescanCh 事件丢失时(如: inotify 队列溢出, busCh 满了丢弃)会收到通知, 收到后应全量扫描监听的 path
+func (w *Watch) RescanCh() <-chan struct{} {
+	return w.rescanCh
+}
+
+// triggerRescan 通知全量扫描, 未处理的通知只保留一个
+func (w *Watch) triggerRescan() {
+	select {
+	case w.rescanCh <- struct{}{}:
+		plg.Warning("watch event is lost, it will rescan")
+	default:
+	}
+}
+
+// resync 事件丢失后同步监听的状态
+// 说明:
+//  1. 已出现的 pending path 开始监听
+//  2. 已删除的 path 转为 pending
+//  3. 递归监听的目录补充监听新的子目录
+func (w *Watch) resync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path, info := range w.fileMap {
+		if _, err := os.Lstat(path); err != nil {
+			delete(w.fileMap, path)
+			w.removeSubDirs(path)
+			if err := w.addPending(info); err != nil {
+				plg.Errorf("w.addPending %q is failed, err: %v", path, err)
+			}
+			continue
+		}
+		if info.IsDir && info.Recursive && !info.Poll {
+			if err := w.addSubDirs(info, path); err != nil {
+				plg.Errorf("w.addSubDirs %q is failed, err: %v", path, err)
+			}
+		}
+	}
+	for path, info := range w.pendingMap {
+		st, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		delete(w.pendingMap, path)
+		info.IsDir = st.IsDir()
+		if err := w.add(info); err != nil {
+			plg.Errorf("w.add %q is failed, err: %v", path, err)
+		}
+	}
+}
+
+// emitActivated 发送已出现的 pending path, 返回 created 是否为 pending path
+func (w *Watch) emitActivated(busCh chan *WatchFileInfo, created string) bool {
+	isPending := false
+	for _, watchFileInfo := range w.activatePending(created) {
+		if watchFileInfo.Path == created {
+			isPending = true
+		}
+		w.send(busCh, watchFileInfo)
+	}
+	return isPending
+}
+
+// toPending 已监听的 path 被删除后转为 pending
+func (w *Watch) toPending(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	info, ok := w.fileMap[path]
+	if !ok {
+		return
+	}
+	delete(w.fileMap, path)
+	w.removeSubDirs(path)
+	if err := w.addPending(info); err != nil {
+		plg.Errorf("w.addPending %q is failed, err: %v", path, err)
+	}
+}
+
 func (w *Watch) getWatchFileInfo(filename string) *WatchFileInfo {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 	var (
 		ok            bool
 		watchFileInfo *WatchFileInfo
 	)
 	// 这里查找2次, filename 为文件全路径
 	// 如果根据 filename 没有查询到, 再按照 filename 目录查询下
-	for i := 0; i < 2; i++ {
-		watchFileInfo, ok = w.fileMap[filename]
-		if !ok {
-			filename = filepath.Dir(filename)
-			continue
+	dir := filepath.Dir(filename)
+	for _, path := range []string{filename, dir} {
+		if watchFileInfo, ok = w.fileMap[path]; ok {
+			break
+		}
+	}
+	// 递归监听的子目录, 按所在的目录查询
+	if !ok {
+		if root, has := w.subDirMap[dir]; has {
+			watchFileInfo = w.fileMap[root]
 		}
-		break
 	}
 	return watchFileInfo.copy()
 }
 
 func (w *Watch) inEvenOps(target fs.Op, ins ...fs.Op) bool {
 	for _, in := range ins {
-		if in == target {
+		if target.Has(in) {
 			return true
 		}
 	}
@@ -179,13 +601,13 @@ func (w *Watch) inEvenOps(target fs.Op, ins ...fs.Op) bool {
 
 // WatchList 查询监听的所有 path
 // 格式:
-// ----------------------
-// |  WATCH-PATH |  DIR |
-// ----------------------
-// |  xxxx       |  true |
-// -----------------------
+// -----------------------------------------
+// |  WATCH-PATH |  DIR  | PENDING | POLL  |
+// -----------------------------------------
+// |  xxxx       |  true | false   | false |
+// -----------------------------------------
 func (w *Watch) WatchList() string {
-	header := []string{"WATCH-PATH", "DIR"}
+	header := []string{"WATCH-PATH", "DIR", "PENDING", "POLL"}
 	buffer := new(bytes.Buffer)
 	buffer.WriteByte('\n')
 
@@ -193,13 +615,19 @@ func (w *Watch) WatchList() string {
 	table.SetHeader(header)
 	table.SetRowLine(true)
 	table.SetCenterSeparator("|")
-	for path, watchFileInfo := range w.fileMap {
-		data := []string{
-			path,
-			base.ToString(watchFileInfo.IsDir),
+	w.mu.RLock()
+	for _, m := range []map[string]*WatchFileInfo{w.fileMap, w.pendingMap} {
+		for path, watchFileInfo := range m {
+			data := []string{
+				path,
+				base.ToString(watchFileInfo.IsDir),
+				base.ToString(watchFileInfo.Pending),
+				base.ToString(watchFileInfo.Poll),
+			}
+			table.Append(data)
 		}
-		table.Append(data)
 	}
+	w.mu.RUnlock()
 	table.Render()
 	return buffer.String()
 }
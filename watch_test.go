@@ -2,8 +2,12 @@ package pslog
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	fs "github.com/fsnotify/fsnotify"
 )
 
 func TestWatch(t *testing.T) {
@@ -50,3 +54,116 @@ func TestRenameWatch(t *testing.T) {
 	os.Rename(targetLog, newLog)
 	t.Log(f.Name())
 }
+
+func TestDrainWatch(t *testing.T) {
+	ps := &PsLog{watchCh: make(chan *WatchFileInfo, 8)}
+	events := []*WatchFileInfo{
+		{Op: fs.Write, ChangedFilename: "a.log"},
+		{Op: fs.Write, ChangedFilename: "b.log"},
+		{Op: fs.Write, ChangedFilename: "a.log"},
+		{Op: fs.Rename, ChangedFilename: "a.log"},
+		{Op: fs.Write, ChangedFilename: "a.log"},
+		{Op: fs.Write, ChangedFilename: "a.log"},
+		{Op: fs.Write, ChangedFilename: "b.log"},
+	}
+	for _, event := range events[1:] {
+		ps.watchCh <- event
+	}
+
+	res := ps.drainWatch(events[0])
+	got := make([]string, 0, len(res))
+	for _, event := range res {
+		got = append(got, event.ChangedFilename+":"+event.Op.String())
+	}
+	want := "a.log:WRITE,b.log:WRITE,a.log:RENAME,a.log:WRITE"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got: %v", got)
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	w, err := NewWatch(WithWatchOverflow(OverflowRescan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	busCh := make(chan *WatchFileInfo, 1)
+	if !w.send(busCh, &WatchFileInfo{}) {
+		t.Fatal("send should be ok")
+	}
+	// busCh 满了丢弃, 并通知全量扫描
+	if w.send(busCh, &WatchFileInfo{}) || w.send(busCh, &WatchFileInfo{}) {
+		t.Fatal("send should be dropped")
+	}
+	select {
+	case <-w.RescanCh():
+	default:
+		t.Fatal("rescan is not triggered")
+	}
+	select {
+	case <-w.RescanCh():
+		t.Fatal("rescan should be merged")
+	default:
+	}
+}
+
+func TestWatchAddAfterClose(t *testing.T) {
+	w, err := NewWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	busCh := make(chan *WatchFileInfo, 1)
+	w.Watch(busCh)
+	w.Close()
+	for range busCh {
+	}
+
+	if err := w.Add(t.TempDir()); err == nil {
+		t.Error("add after close should is failed")
+	}
+	if err := w.AddRecursive(t.TempDir(), 0); err == nil {
+		t.Error("add recursive after close should is failed")
+	}
+}
+
+func TestWatchRemove(t *testing.T) {
+	w, err := NewWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")
+	for _, name := range []string{a, b} {
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Add(a, b, dir); err != nil {
+		t.Fatal(err)
+	}
+	watched := func() bool {
+		for _, v := range w.watcher.WatchList() {
+			if v == dir {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 目录还被其他 path 使用时, 不取消监听
+	if err := w.Remove(a, dir); err != nil {
+		t.Fatal(err)
+	}
+	if !watched() {
+		t.Fatal("dir should is watched")
+	}
+	if err := w.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	if watched() {
+		t.Error("dir should is not watched")
+	}
+}